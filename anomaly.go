@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	tui "github.com/charmbracelet/bubbletea"
+)
+
+// anomalyScore returns the z-score of the item's count in the latest complete tick, relative to the exponentially
+// weighted moving average and variance of its per-tick counts in the window before it (oldest first).
+// The standard deviation is at least that of a Poisson process with the same mean (and at least 1),
+// so that sparse or constant series do not produce huge scores. The between function returns the item's count
+// in the tick ages [from, to), see countBetween.
+func anomalyScore(windowSize int, between func(from, to float64) float64) float64 {
+	n := float64(windowSize)
+	if n < 3 {
+		return 0
+	}
+	alpha := config.AnomalyAlpha
+	mean := between(n-1, n)
+	var variance float64
+	for age := n - 2; age >= 2; age-- {
+		diff := between(age, age+1) - mean
+		mean += alpha * diff
+		variance = (1 - alpha) * (variance + alpha*diff*diff)
+	}
+	latest := between(1, 2)
+	std := max(math.Sqrt(variance), math.Sqrt(max(mean, 1)))
+	return (latest - mean) / std
+}
+
+// updateAnomalies scores the list items, and with -anomaly-bell, has the bell rung (see bellCmd) if an item
+// became anomalous. The caller must hold mu.
+func (m *model) updateAnomalies() {
+	if config.AnomalyThreshold <= 0 {
+		return
+	}
+	scores := make([]float64, len(m.listItems))
+	anomalous := make(map[string]bool)
+	var ring bool
+	for i, item := range m.listItems {
+		sh := m.shards[shardIndex(item.Item, len(m.shards))]
+		sh.mu.Lock()
+		scores[i] = anomalyScore(sh.sketches[m.window].WindowSize, func(from, to float64) float64 {
+			return sh.countBetween(m.window, item, from, to)
+		})
+		sh.mu.Unlock()
+		if math.Abs(scores[i]) >= config.AnomalyThreshold {
+			anomalous[item.Item] = true
+			ring = ring || !m.bellItems[item.Item]
+		}
+	}
+	m.listAnomalies, m.anomalous, m.bellItems = scores, anomalous, anomalous
+	m.bell = m.bell || (ring && config.AnomalyBell)
+}
+
+// bellCmd rings the terminal bell through the renderer, if an item became anomalous since the last call.
+func (m *model) bellCmd() tui.Cmd {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.bell {
+		return nil
+	}
+	m.bell = false
+	return tui.Println("\a")
+}
+
+// formatAnomaly formats an anomaly badge, e.g. SPIKE z=+5.2.
+func formatAnomaly(score float64) string {
+	if score > 0 {
+		return fmt.Sprintf("SPIKE z=%+.1f", score)
+	}
+	return fmt.Sprintf("DROP z=%+.1f", score)
+}
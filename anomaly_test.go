@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/keilerkonzept/topk"
+	"github.com/keilerkonzept/topk/heap"
+)
+
+func TestAnomalyScore(t *testing.T) {
+	testModel(t, func(c *Config) { c.AnomalyAlpha = 0.5 })
+	// series by tick age, latest (incomplete) tick first
+	series := func(counts ...float64) func(from, to float64) float64 {
+		return func(from, to float64) float64 {
+			if age := int(from); age < len(counts) {
+				return counts[age]
+			}
+			return 0
+		}
+	}
+	tests := []struct {
+		name       string
+		windowSize int
+		between    func(from, to float64) float64
+		want       float64
+	}{
+		{"too short", 2, series(0, 100), 0},
+		{"constant", 6, series(50, 10, 10, 10, 10, 10), 0},
+		{"spike", 6, series(0, 26, 10, 10, 10, 10), 16 / math.Sqrt(10)},
+		{"drop", 6, series(0, 6, 10, 10, 10, 10), -4 / math.Sqrt(10)},
+		{"sparse", 6, series(0, 3, 0, 0, 0, 0), 3},
+		// mean 10+0.5*(-4)=8, then 8+0.5*4=10; variance 0.5*(0+0.5*16)=4, then 0.5*(4+0.5*16)=6: std sqrt(10) > sqrt(6)
+		{"noisy", 5, series(0, 16, 12, 6, 10), 6 / math.Sqrt(10)},
+	}
+	for _, tt := range tests {
+		if got := anomalyScore(tt.windowSize, tt.between); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: got score %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateAnomalies(t *testing.T) {
+	m := testModel(t, func(c *Config) {
+		c.Windows = []time.Duration{10 * c.TickSize}
+		c.AnomalyThreshold = 4
+		c.AnomalyBell = true
+	})
+	for range 8 {
+		m.add(record{Item: "steady", Count: 10})
+		m.add(record{Item: "spike", Count: 10})
+		m.queueTicks(1)
+	}
+	m.add(record{Item: "steady", Count: 10})
+	m.add(record{Item: "spike", Count: 100})
+	m.queueTicks(1)
+	flushQueues(m)
+	for _, item := range []string{"steady", "spike"} {
+		m.listItems = append(m.listItems, heap.Item{Item: item, Fingerprint: topk.Fingerprint(item)})
+	}
+
+	m.updateAnomalies()
+	if m.anomalous["steady"] || !m.anomalous["spike"] {
+		t.Errorf("got anomalous items %v (scores %v), want only spike", m.anomalous, m.listAnomalies)
+	}
+	if m.bellCmd() == nil {
+		t.Error("the bell did not ring for the spike")
+	}
+	if m.bellCmd() != nil {
+		t.Error("the bell rang twice for the spike")
+	}
+	m.updateAnomalies()
+	if m.bellCmd() != nil {
+		t.Error("the bell rang again for a spike that was already anomalous")
+	}
+
+	listItems := m.listItems
+	m.showSnapshot(archiveSnapshot{}) // scrolling the archive clears the list
+	m.listItems = listItems
+	m.updateAnomalies()
+	if m.bellCmd() != nil {
+		t.Error("the bell rang again after returning from the archive")
+	}
+}
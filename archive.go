@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/keilerkonzept/topk/heap"
+)
+
+// archiveItem is an item's count in an archived snapshot.
+type archiveItem struct {
+	Item  string
+	Count uint32
+}
+
+// archiveSnapshot is the top-K of each window at one point in time.
+type archiveSnapshot struct {
+	Time   time.Time
+	Items  [][]archiveItem // per-window top-K items, by descending count
+	Totals []streamTick    // per-window stream totals
+}
+
+// archive keeps the latest -archive-length snapshots, taken every -archive-interval. With -archive-file, they are also
+// appended to the file (one JSON object per line), which is compacted to the kept snapshots every -archive-length
+// snapshots and on start. Snapshots are added by a writer goroutine, so that the shards do not wait for the file.
+type archive struct {
+	mu        sync.Mutex
+	snapshots []archiveSnapshot // oldest first
+	err       error             // error of the latest file write, if it failed
+
+	path     string
+	file     *os.File
+	appended int // snapshots appended to the file since it was compacted, only used by the writer
+
+	pending chan archiveSnapshot // snapshots to add, see write
+	quit    chan struct{}        // closed by Close
+	done    chan struct{}        // closed when the writer is done
+}
+
+// archiveQueueSize is the number of snapshots that can wait for the archive's writer.
+const archiveQueueSize = 16
+
+// openArchive returns an archive with the latest snapshots from the file at the given path (if any),
+// and starts its writer.
+func openArchive(path string) (*archive, error) {
+	a := &archive{
+		path:    path,
+		pending: make(chan archiveSnapshot, archiveQueueSize),
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if path != "" {
+		f, err := os.Open(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+		case err != nil:
+			return nil, err
+		default:
+			defer f.Close()
+			scanner := bufio.NewScanner(f)
+			scanner.Buffer(nil, 64<<20)
+			for line := 1; scanner.Scan(); line++ {
+				var s archiveSnapshot
+				if err := json.Unmarshal(scanner.Bytes(), &s); err != nil {
+					return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+				}
+				a.add(s)
+			}
+			if err := scanner.Err(); err != nil {
+				return nil, err
+			}
+		}
+		if err := a.compact(a.snapshots); err != nil {
+			return nil, err
+		}
+	}
+	go a.write()
+	return a, nil
+}
+
+// add adds the snapshot, replacing any snapshots taken at or after its time (e.g. when replaying a stream).
+// The caller must hold mu, if the writer is running.
+func (a *archive) add(s archiveSnapshot) {
+	i, _ := slices.BinarySearchFunc(a.snapshots, s.Time, compareSnapshotTime)
+	a.snapshots = append(a.snapshots[:i], s)
+	if n := len(a.snapshots) - config.ArchiveLength; n > 0 {
+		a.snapshots = slices.Delete(a.snapshots, 0, n)
+	}
+}
+
+// Add queues the snapshot for the writer. Snapshots added after Close are dropped.
+func (a *archive) Add(s archiveSnapshot) {
+	select {
+	case a.pending <- s:
+	case <-a.quit:
+	}
+}
+
+// write adds the queued snapshots, dropping the oldest one if the archive is full, and appends them to the file,
+// until Close is called. The latest write error is kept for the status line.
+func (a *archive) write() {
+	defer close(a.done)
+	for {
+		select {
+		case s := <-a.pending:
+			a.writeSnapshot(s)
+		case <-a.quit:
+			for {
+				select {
+				case s := <-a.pending:
+					a.writeSnapshot(s)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (a *archive) writeSnapshot(s archiveSnapshot) {
+	a.mu.Lock()
+	a.add(s)
+	var kept []archiveSnapshot
+	if a.appended++; a.appended >= config.ArchiveLength {
+		kept = slices.Clone(a.snapshots)
+	}
+	a.mu.Unlock()
+	if a.file == nil {
+		return
+	}
+	var err error
+	if kept != nil {
+		err = a.compact(kept)
+	} else {
+		err = json.NewEncoder(a.file).Encode(s)
+	}
+	a.mu.Lock()
+	a.err = err
+	a.mu.Unlock()
+}
+
+// compact atomically replaces the file with the given snapshots, and reopens it for appending.
+// The file is kept open for appending until it has been replaced, so that a failed compaction is retried
+// with the next snapshot.
+func (a *archive) compact(snapshots []archiveSnapshot) error {
+	f, err := os.CreateTemp(filepath.Dir(a.path), filepath.Base(a.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, s := range snapshots {
+		if err := enc.Encode(s); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), a.path); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	if a.file != nil {
+		a.file.Close()
+	}
+	a.file, a.appended = file, 0
+	return nil
+}
+
+// Close writes the queued snapshots, stops the writer, and closes the file.
+func (a *archive) Close() error {
+	close(a.quit)
+	<-a.done
+	if a.file == nil {
+		return nil
+	}
+	return a.file.Close()
+}
+
+// Err returns the error of the latest file write, if it failed.
+func (a *archive) Err() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.err
+}
+
+func (a *archive) Len() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.snapshots)
+}
+
+// Step returns the time of the snapshot before (older) or after the one at time t, or of the latest snapshot
+// if t is zero. It returns the zero time when stepping past the latest snapshot, or if the archive is empty.
+func (a *archive) Step(t time.Time, older bool) time.Time {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.snapshots) == 0 || (t.IsZero() && !older) {
+		return time.Time{}
+	}
+	if t.IsZero() {
+		return a.snapshots[len(a.snapshots)-1].Time
+	}
+	i, _ := slices.BinarySearchFunc(a.snapshots, t, compareSnapshotTime)
+	if older {
+		return a.snapshots[max(0, i-1)].Time
+	}
+	if i+1 >= len(a.snapshots) {
+		return time.Time{}
+	}
+	return a.snapshots[i+1].Time
+}
+
+// Before returns up to n of the latest snapshots taken at or before t, oldest first.
+func (a *archive) Before(t time.Time, n int) []archiveSnapshot {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	j, found := slices.BinarySearchFunc(a.snapshots, t, compareSnapshotTime)
+	if found {
+		j++
+	}
+	return slices.Clone(a.snapshots[max(0, j-n):j])
+}
+
+func compareSnapshotTime(s archiveSnapshot, t time.Time) int {
+	return s.Time.Compare(t)
+}
+
+// pendingSnapshot is a snapshot whose top-K items are being collected from the shards, see archiveTick.
+type pendingSnapshot struct {
+	mu        sync.Mutex
+	snapshot  archiveSnapshot
+	items     [][]heap.Item // per-window top-K items of the shards so far
+	remaining int           // number of shards yet to add their items
+}
+
+// add adds the shard's top-K items, and hands the snapshot to the archive's writer once all shards have added theirs.
+// The caller must hold the shard's lock.
+func (p *pendingSnapshot) add(sh *shard, a *archive) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for w := range p.items {
+		p.items[w] = append(p.items[w], sh.topK(w)...)
+	}
+	if p.remaining--; p.remaining > 0 {
+		return
+	}
+	for _, items := range p.items {
+		archived := make([]archiveItem, 0, config.K)
+		for _, item := range topItems(items, config.K) {
+			archived = append(archived, archiveItem{Item: item.Item, Count: item.Count})
+		}
+		p.snapshot.Items = append(p.snapshot.Items, archived)
+	}
+	a.Add(p.snapshot)
+}
+
+// archiveTick archives the top-K items of each window, if -archive-interval has passed since the last snapshot.
+// The stream totals are taken right away, and the items are taken by each shard once it has applied the records
+// queued before the snapshot (see applyQueued).
+func (m *model) archiveTick(t time.Time) {
+	if m.archive == nil {
+		return
+	}
+	m.mu.Lock()
+	due := t.Sub(m.archivedAt) >= config.ArchiveInterval
+	if due {
+		m.archivedAt = t
+	}
+	m.mu.Unlock()
+	if !due {
+		return
+	}
+	p := &pendingSnapshot{
+		snapshot:  archiveSnapshot{Time: t},
+		items:     make([][]heap.Item, len(config.Windows)),
+		remaining: len(m.shards),
+	}
+	for _, size := range windowTicks() {
+		total, _ := m.totals.Window(size)
+		p.snapshot.Totals = append(p.snapshot.Totals, total)
+	}
+	for _, sh := range m.shards {
+		sh.queue <- ingestOp{snapshot: p}
+	}
+}
+
+// scrollArchive scrolls the leaderboard and the plot to the next older or newer snapshot, or back to the live counts
+// after the latest one.
+func (m *model) scrollArchive(older bool) {
+	if m.archive == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.scrollTime = m.archive.Step(m.scrollTime, older)
+}
+
+// scrolledSnapshots returns up to n archived snapshots up to the one the view is scrolled back to, oldest first,
+// or nil for the live view.
+func (m *model) scrolledSnapshots(n int) []archiveSnapshot {
+	m.mu.Lock()
+	t := m.scrollTime
+	m.mu.Unlock()
+	if t.IsZero() {
+		return nil
+	}
+	return m.archive.Before(t, n)
+}
+
+// showSnapshot lists the snapshot's top-K items of the active window, instead of the live ones.
+func (m *model) showSnapshot(s archiveSnapshot) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var items []heap.Item
+	if m.window < len(s.Items) {
+		for _, item := range s.Items[m.window] {
+			items = append(items, heap.Item{Item: item.Item, Count: item.Count})
+		}
+	}
+	m.listItems = items
+	m.listCounts, m.listExact, m.listErrors, m.listTies = nil, nil, nil, nil
+	m.listDistinct, m.listQuantiles, m.listChanges, m.listTree = nil, nil, nil, nil
+	m.listAnomalies, m.anomalous = nil, nil
+	m.listNew, m.listFirstSeen = nil, nil
+	m.windowTotal, m.windowRate = streamTick{}, streamRate{}
+	if m.window < len(s.Totals) {
+		total := s.Totals[m.window]
+		seconds := config.Windows[m.window].Seconds()
+		m.windowTotal = total
+		m.windowRate = streamRate{Events: float64(total.Events) / seconds, Weight: float64(total.Weight) / seconds}
+	}
+	m.updateThroughput()
+}
+
+// archiveHistory returns the items' counts in the given window of each snapshot (oldest first, padded with zeros
+// to the given length), as average counts per tick (or with -mode=decayed, as decayed counts).
+func archiveHistory(snapshots []archiveSnapshot, window int, items []heap.Item, length int) map[string][]float64 {
+	history := make(map[string][]float64, len(items))
+	for _, item := range items {
+		history[item.Item] = make([]float64, length)
+	}
+	scale := 1.0
+	if config.Mode != modeDecayed {
+		scale = 1 / float64(windowTicks()[window])
+	}
+	for j, s := range snapshots {
+		if window >= len(s.Items) {
+			continue
+		}
+		for _, item := range s.Items[window] {
+			if series, ok := history[item.Item]; ok {
+				series[length-len(snapshots)+j] = float64(item.Count) * scale
+			}
+		}
+	}
+	return history
+}
+
+func (m *model) archiveStatus() string {
+	status := fmt.Sprintf("archive %s/%s", formatSI(float64(m.archive.Len())), formatSI(float64(config.ArchiveLength)))
+	if err := m.archive.Err(); err != nil {
+		status += ", write failed: " + err.Error()
+	}
+	return status
+}
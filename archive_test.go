@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchiveFile(t *testing.T) {
+	testModel(t, func(c *Config) { c.ArchiveLength = 3 })
+	path := filepath.Join(t.TempDir(), "archive.jsonl")
+	a, err := openArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1700000000, 0).UTC()
+	for i := range 5 {
+		a.Add(archiveSnapshot{Time: start.Add(time.Duration(i) * time.Minute), Items: [][]archiveItem{{{"a", uint32(i)}}}})
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := openArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	if got := restored.Len(); got != 3 {
+		t.Fatalf("restored %d snapshots, want 3", got)
+	}
+	if got, want := restored.Step(time.Time{}, true), start.Add(4*time.Minute); !got.Equal(want) {
+		t.Errorf("got latest snapshot at %v, want %v", got, want)
+	}
+	if got := restored.Before(start.Add(2*time.Minute), 3); len(got) != 1 || got[0].Items[0][0].Count != 2 {
+		t.Errorf("got snapshots %v before the third, want only the third", got)
+	}
+}
+
+func TestArchiveFileError(t *testing.T) {
+	testModel(t, func(c *Config) { c.ArchiveLength = 2 })
+	dir := filepath.Join(t.TempDir(), "archive")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	a, err := openArchive(filepath.Join(dir, "archive.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1700000000, 0)
+	for i := range 2 { // the second snapshot compacts the file, which fails without its directory
+		a.Add(archiveSnapshot{Time: start.Add(time.Duration(i) * time.Minute)})
+	}
+	a.Close()
+	if err := a.Err(); err == nil {
+		t.Fatal("got no error compacting into a removed directory")
+	}
+	if a.file == nil {
+		t.Error("the file was closed by the failed compaction")
+	}
+	if got := a.Len(); got != 2 {
+		t.Errorf("got %d snapshots, want both kept in memory", got)
+	}
+	m := &model{archive: a}
+	if status := m.archiveStatus(); !strings.Contains(status, "write failed") {
+		t.Errorf("got status %q, want the write error", status)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/keilerkonzept/topk"
+	"github.com/keilerkonzept/topk/heap"
+)
+
+// autoExact counts a shard's items exactly while there are few of them, see -exact-below.
+// The exact counts are dropped once the longest window holds limit distinct items, and the shard falls back
+// to its sketches (which count all along). After another window, the exact counts start over, and take over again
+// once they cover the longest window.
+type autoExact struct {
+	exact    *exactCounter // nil while the shard counts with its sketches
+	windows  []int         // window sizes in ticks
+	limit    int           // number of distinct items in a window at which the exact counts are dropped
+	ticks    int           // ticks since the exact counts were started or dropped
+	complete bool          // whether the exact counts cover the longest window
+}
+
+func newAutoExact(windows []int, limit int) *autoExact {
+	return &autoExact{
+		exact:    newExactCounter(windows),
+		windows:  windows,
+		limit:    limit,
+		complete: true,
+	}
+}
+
+// exactLimit returns the -exact-below limit of each shard.
+func exactLimit() int {
+	return (config.ExactBelow + config.Shards - 1) / config.Shards
+}
+
+// Active returns whether the exact counts are used instead of the sketches.
+func (a *autoExact) Active() bool {
+	return a.exact != nil && a.complete
+}
+
+// Add counts the item, and drops the exact counts if there are too many distinct items.
+func (a *autoExact) Add(item string, count uint32) {
+	if a.exact == nil {
+		return
+	}
+	a.exact.Add(item, count)
+	if a.exact.Cardinality() >= a.limit {
+		a.exact, a.ticks, a.complete = nil, 0, false
+	}
+}
+
+func (a *autoExact) Ticks(n int) {
+	a.ticks += n
+	if a.exact == nil {
+		if a.ticks >= maxOf(a.windows) {
+			a.Restart()
+		}
+		return
+	}
+	a.exact.Ticks(n)
+	if a.ticks >= maxOf(a.windows) {
+		a.complete = true
+	}
+}
+
+// Restart starts the exact counts over, e.g. after the sketches were replaced.
+func (a *autoExact) Restart() {
+	a.exact, a.ticks, a.complete = newExactCounter(a.windows), 0, false
+}
+
+// Cardinality returns the number of distinct items in the longest window.
+func (e *exactCounter) Cardinality() int {
+	var n int
+	for _, totals := range e.totals {
+		n = max(n, len(totals))
+	}
+	return n
+}
+
+// Items returns the items of the given window with their exact counts, in no particular order.
+func (e *exactCounter) Items(window int) []heap.Item {
+	items := make([]heap.Item, 0, len(e.totals[window]))
+	for item, count := range e.totals[window] {
+		items = append(items, heap.Item{Item: item, Count: count, Fingerprint: topk.Fingerprint(item)})
+	}
+	return items
+}
+
+// CountBetween returns the item's exact count in the tick ages [from, to), where age 0 is the current tick.
+// Fractional ticks are counted pro rata.
+func (e *exactCounter) CountBetween(item string, from, to float64) float64 {
+	var count float64
+	for age := int(from); age < len(e.ring) && float64(age) < to; age++ {
+		if c := e.ring[(e.head+age)%len(e.ring)][item]; c > 0 {
+			overlap := min(float64(age+1), to) - max(float64(age), from)
+			count += float64(c) * overlap
+		}
+	}
+	return count
+}
+
+// exactActive returns whether the shard counts exactly, see autoExact. The caller must hold mu.
+func (sh *shard) exactActive() bool {
+	return sh.auto != nil && sh.auto.Active()
+}
+
+// exactMode returns the number of shards that count exactly, and their number of distinct items in the given window.
+func (m *model) exactMode(window int) (shards, items int) {
+	if config.ExactBelow <= 0 {
+		return 0, 0
+	}
+	for _, sh := range m.shards {
+		sh.mu.Lock()
+		if sh.exactActive() {
+			shards++
+			items += len(sh.auto.exact.totals[window])
+		}
+		sh.mu.Unlock()
+	}
+	return shards, items
+}
+
+func (m *model) exactModeStatus() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch m.exactShards {
+	case len(m.shards):
+		return fmt.Sprintf("exact counts (%s items)", formatSI(float64(m.exactItems)))
+	case 0:
+		return fmt.Sprintf("sketch counts (exact below %s items)", formatSI(float64(config.ExactBelow)))
+	default:
+		return fmt.Sprintf("exact counts on %d/%d shards", m.exactShards, len(m.shards))
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAutoExact(t *testing.T) {
+	a := newAutoExact([]int{4}, 3)
+	a.Add("a", 1)
+	a.Add("b", 1)
+	if !a.Active() {
+		t.Fatalf("got sketch counts below the limit")
+	}
+	a.Add("c", 1) // reaches the limit
+	if a.Active() || a.exact != nil {
+		t.Fatalf("got exact counts at the limit")
+	}
+	steps := []struct {
+		ticks      int
+		add        string
+		wantActive bool
+	}{
+		{3, "", false},
+		{1, "a", false}, // starts over, but does not cover the window yet
+		{3, "b", false},
+		{1, "", true},
+		{1, "c", true},
+	}
+	for i, step := range steps {
+		a.Ticks(step.ticks)
+		if step.add != "" {
+			a.Add(step.add, 1)
+		}
+		if got := a.Active(); got != step.wantActive {
+			t.Errorf("step %d: got active %v, want %v", i, got, step.wantActive)
+		}
+	}
+}
+
+func TestExactActiveAfterRestore(t *testing.T) {
+	configure := func(c *Config) {
+		c.Shards = 1
+		c.Windows = []time.Duration{4 * c.TickSize}
+		c.ExactBelow = 3
+	}
+	saved := testModel(t, configure)
+	saved.add(record{Item: "a", Count: 5})
+	saved.add(record{Item: "b", Count: 3})
+	flushQueues(saved)
+	if shards, items := saved.exactMode(0); shards != 1 || items != 2 {
+		t.Errorf("got %d exact shards with %d items, want 1 with 2", shards, items)
+	}
+	saved.latestTick = time.Now().Truncate(config.TickSize)
+	path := filepath.Join(t.TempDir(), "state")
+	if err := saved.saveState(path); err != nil {
+		t.Fatal(err)
+	}
+	saved.add(record{Item: "c"})
+	flushQueues(saved)
+	if saved.shards[0].exactActive() {
+		t.Errorf("got exact counts with 3 items, want the sketch")
+	}
+
+	m := testModel(t, configure)
+	st, err := loadState(path, m.shards)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.restoreState(st)
+	flushQueues(m)
+	// the exact counts start over, and are not used until they cover the window
+	if m.shards[0].exactActive() {
+		t.Errorf("got exact counts right after the restore")
+	}
+	if got := m.count(0, "a"); got != 5 {
+		t.Errorf("got restored count %d for a, want 5", got)
+	}
+	m.queueTicks(4)
+	flushQueues(m)
+	if !m.shards[0].exactActive() {
+		t.Errorf("got sketch counts a window after the restore")
+	}
+}
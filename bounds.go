@@ -0,0 +1,54 @@
+package main
+
+import (
+	"math"
+)
+
+// errorBound returns the estimated error bound of a sketch count at the -confidence level, for a window with the
+// given total weight (with -mode=decayed, the total of the decayed counts). The sketch only underestimates (but for
+// fingerprint collisions), so the true count is between the count and the count plus the bound.
+//
+// In each row of a shard's sketch, the other items sharing the item's bucket have a total weight of about
+// total/(shards·width) on average. By Markov's inequality, they exceed (1-confidence)^(-1/depth) times that in all
+// depth rows with a probability of at most 1-confidence. Each of their increments only decays the item's counter with
+// probability decay^count, which (for collisions spread evenly over the item's own increments) amounts to about
+// 1/(count·ln(1/decay)) of them. The bound is at most the total weight of the other items.
+func errorBound(count uint32, total float64) float64 {
+	err := total / float64(config.Shards*shardWidth()) * math.Pow(1-config.Confidence, -1/float64(config.Depth))
+	if config.Decay > 0 && config.Decay < 1 && count > 0 {
+		err *= min(1, 1/(float64(count)*math.Log(1/config.Decay)))
+	}
+	return max(0, min(err, total-float64(count)))
+}
+
+// updateErrorBounds updates the error bounds of the list items' counts (zero for exactly counted items), and marks
+// the items of the top view whose counts are within the error bounds of a neighbour's. The caller must hold mu.
+func (m *model) updateErrorBounds() {
+	if !config.ErrorBounds {
+		return
+	}
+	total := float64(m.windowTotal.Weight)
+	if config.Mode == modeDecayed {
+		total = m.totals.Decayed()
+	}
+	m.listErrors = make([]float64, len(m.listItems))
+	for i, item := range m.listItems {
+		sh := m.shards[shardIndex(item.Item, len(m.shards))]
+		sh.mu.Lock()
+		exact := sh.exactActive()
+		sh.mu.Unlock()
+		if !exact {
+			m.listErrors[i] = errorBound(item.Count, total)
+		}
+	}
+	m.listTies = make([]bool, len(m.listItems))
+	if m.view != viewTop || config.Hierarchy != "" {
+		return
+	}
+	for i := 1; i < len(m.listItems); i++ {
+		diff := math.Abs(float64(m.listItems[i-1].Count) - float64(m.listItems[i].Count))
+		if diff <= m.listErrors[i-1]+m.listErrors[i] {
+			m.listTies[i-1], m.listTies[i] = true, true
+		}
+	}
+}
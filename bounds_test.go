@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/keilerkonzept/topk/heap"
+)
+
+func TestErrorBound(t *testing.T) {
+	// 1/(shards·width) of the total, times (1-confidence)^(-1/depth) = 10
+	configure := func(c *Config) {
+		c.Shards = 1
+		c.Width = 100
+		c.Depth = 2
+		c.Confidence = 0.99
+	}
+	tests := []struct {
+		decay       float64
+		count       uint32
+		total, want float64
+	}{
+		{0, 10, 1000, 100},
+		{0, 0, 1000, 100},
+		{0, 950, 1000, 50}, // at most the other items' weight
+		{0, 0, 0, 0},
+		{0.9, 1, 1000, 100}, // a single increment is decayed by every collision
+		{0.9, 100, 1000, 100 / (100 * math.Log(1/0.9))}, // larger counts decay with probability 0.9^count
+		{1, 100, 1000, 100},
+	}
+	for _, tt := range tests {
+		testModel(t, func(c *Config) {
+			configure(c)
+			c.Decay = tt.decay
+		})
+		if got := errorBound(tt.count, tt.total); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("errorBound(%d, %v) with decay %v = %v, want %v", tt.count, tt.total, tt.decay, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateErrorBounds(t *testing.T) {
+	configure := func(c *Config) {
+		c.ErrorBounds = true
+		c.Shards = 1
+		c.Width = 100
+		c.Depth = 2
+		c.Confidence = 0.99
+		c.Decay = 0
+	}
+	m := testModel(t, configure)
+	for _, count := range []uint32{100, 95, 50, 29} {
+		m.listItems = append(m.listItems, heap.Item{Count: count})
+	}
+	m.windowTotal = streamTick{Events: 100, Weight: 100}
+	m.updateErrorBounds()
+	// bounds of 10, capped at the other items' weight
+	if want := []float64{0, 5, 10, 10}; !approxEqual(m.listErrors, want) {
+		t.Errorf("got error bounds %v, want %v", m.listErrors, want)
+	}
+	// 100 and 95 are within their bounds, 95 and 50 and 50 and 29 are not
+	if want := []bool{true, true, false, false}; !reflect.DeepEqual(m.listTies, want) {
+		t.Errorf("got ties %v, want %v", m.listTies, want)
+	}
+
+	m = testModel(t, func(c *Config) {
+		configure(c)
+		c.Mode = modeDecayed
+		c.HalfLife = c.TickSize
+	})
+	m.listItems = []heap.Item{{Count: 10}}
+	m.windowTotal = streamTick{Events: 1, Weight: 1000} // not decayed
+	m.totals.Add(1, 100)
+	m.totals.Ticks(1)
+	m.updateErrorBounds()
+	if want := []float64{5}; !approxEqual(m.listErrors, want) {
+		t.Errorf("got error bounds %v in decayed mode, want %v from the decayed total of 50", m.listErrors, want)
+	}
+}
+
+// approxEqual returns whether the values are equal up to rounding errors.
+func approxEqual(got, want []float64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if math.Abs(got[i]-want[i]) > 1e-9*max(1, math.Abs(want[i])) {
+			return false
+		}
+	}
+	return true
+}
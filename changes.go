@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/keilerkonzept/topk/heap"
+)
+
+// Views of the leaderboard, see keys.Changes.
+const (
+	viewTop     = iota // items by count
+	viewRisers         // items by increase from the previous to the recent sub-window
+	viewFallers        // items by decrease from the previous to the recent sub-window
+	viewNew            // new items by count, see -new-windows
+	numViews
+)
+
+var viewNames = [numViews]string{"TOP", "RISERS", "FALLERS", "NEW"}
+
+// viewEnabled returns whether the view is available: risers and fallers need sliding windows,
+// and the new items view needs -new-windows.
+func viewEnabled(view int) bool {
+	switch view {
+	case viewRisers, viewFallers:
+		return config.Mode == modeSliding
+	case viewNew:
+		return config.NewWindows > 0
+	}
+	return true
+}
+
+// viewHelp returns the help of keys.Changes, listing the available views.
+func viewHelp() string {
+	var names []string
+	for view, name := range viewNames {
+		if viewEnabled(view) {
+			names = append(names, strings.ToLower(name))
+		}
+	}
+	return strings.Join(names, "/")
+}
+
+// itemChange is an item's count in the recent sub-window and in the sub-window before it.
+type itemChange struct {
+	Previous, Recent float64
+}
+
+func (c itemChange) Delta() float64 { return c.Recent - c.Previous }
+
+// changeTicks returns the length of the compared sub-windows for a window of the given number of ticks:
+// -change-window, or half of the window if it is shorter than two sub-windows.
+func changeTicks(windowSize int) float64 {
+	ticks := float64(windowSize) / 2
+	if config.ChangeWindow > 0 {
+		ticks = min(ticks, float64(config.ChangeWindow/config.TickSize))
+	}
+	return ticks
+}
+
+// changeCandidates is the number of heap items the sliding sketches keep per top-K item, so that the risers and
+// fallers views can show items that rise (or fall) behind the heavy hitters.
+const changeCandidates = 4
+
+// changes returns the shards' top-K items of the given window with the largest increase (or decrease, for fallers)
+// between the previous and the recent sub-window, and their changes.
+// Only items in the sketches' heaps of changeCandidates·K items (or with -exact-below, all exactly counted items)
+// are considered.
+func (m *model) changes(window int, fallers bool) ([]heap.Item, []itemChange) {
+	var (
+		items   []heap.Item
+		changes = make(map[string]itemChange)
+	)
+	for _, sh := range m.shards {
+		sh.mu.Lock()
+		ticks := changeTicks(sh.sketches[window].WindowSize)
+		for _, item := range sh.items(window) {
+			if item.Count == 0 {
+				continue
+			}
+			items = append(items, item)
+			changes[item.Item] = itemChange{
+				Previous: sh.countBetween(window, item, ticks, 2*ticks),
+				Recent:   sh.countBetween(window, item, 0, ticks),
+			}
+		}
+		sh.mu.Unlock()
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		di, dj := changes[items[i].Item].Delta(), changes[items[j].Item].Delta()
+		if di == dj {
+			return items[i].Item < items[j].Item
+		}
+		if fallers {
+			return di < dj
+		}
+		return di > dj
+	})
+	items = items[:min(len(items), config.K)]
+	out := make([]itemChange, len(items))
+	for i, item := range items {
+		out[i] = changes[item.Item]
+	}
+	return items, out
+}
+
+// nextView switches the leaderboard to the next view.
+func (m *model) nextView() {
+	m.mu.Lock()
+	m.view = (m.view + 1) % numViews
+	for !viewEnabled(m.view) {
+		m.view = (m.view + 1) % numViews
+	}
+	m.mu.Unlock()
+	m.updateTopK()
+}
+
+// formatChange formats an item's change, e.g. +120 (30 → 150).
+func formatChange(c itemChange) string {
+	return fmt.Sprintf("%+.0f (%.0f → %.0f)", c.Delta(), c.Previous, c.Recent)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestChangeTicks(t *testing.T) {
+	tests := []struct {
+		windowSize   int
+		changeWindow time.Duration
+		want         float64
+	}{
+		{60, 0, 30},
+		{5, 0, 2.5},
+		{60, 10 * time.Second, 10},
+		{60, 45 * time.Second, 30},       // at most half of the window
+		{60, 1500 * time.Millisecond, 1}, // whole ticks
+	}
+	for _, tt := range tests {
+		testModel(t, func(c *Config) {
+			c.TickSize = time.Second
+			c.ChangeWindow = tt.changeWindow
+		})
+		if got := changeTicks(tt.windowSize); got != tt.want {
+			t.Errorf("changeTicks(%d) with -change-window %v = %v, want %v", tt.windowSize, tt.changeWindow, got, tt.want)
+		}
+	}
+}
+
+func TestChanges(t *testing.T) {
+	m := testModel(t, func(c *Config) {
+		c.K = 2
+		c.Shards = 1
+		c.Windows = []time.Duration{8 * c.TickSize}
+	})
+	// over 8 ticks, the heavy hitters are steady, a riser starts after 4 ticks, and a faller stops
+	for tick := range 8 {
+		for _, item := range []string{"heavy1", "heavy2", "heavy3"} {
+			m.add(record{Item: item, Count: 100})
+		}
+		if tick >= 4 {
+			m.add(record{Item: "riser", Count: 30})
+		} else {
+			m.add(record{Item: "faller", Count: 50})
+		}
+		if tick < 7 {
+			m.queueTicks(1)
+		}
+	}
+	flushQueues(m)
+
+	var top []string
+	items, _ := m.topK(0)
+	for _, item := range items {
+		top = append(top, item.Item)
+	}
+	if want := []string{"heavy1", "heavy2"}; !reflect.DeepEqual(top, want) {
+		t.Errorf("got top-k %v, want %v", top, want)
+	}
+	tests := []struct {
+		fallers bool
+		want    []string
+		changes []itemChange
+	}{
+		{false, []string{"riser", "heavy1"}, []itemChange{{0, 120}, {400, 400}}},
+		{true, []string{"faller", "heavy1"}, []itemChange{{200, 0}, {400, 400}}},
+	}
+	for _, tt := range tests {
+		items, changes := m.changes(0, tt.fallers)
+		var got []string
+		for _, item := range items {
+			got = append(got, item.Item)
+		}
+		if !reflect.DeepEqual(got, tt.want) || !reflect.DeepEqual(changes, tt.changes) {
+			t.Errorf("fallers=%v: got %v %v, want %v %v", tt.fallers, got, changes, tt.want, tt.changes)
+		}
+	}
+}
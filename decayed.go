@@ -0,0 +1,112 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"github.com/keilerkonzept/topk"
+)
+
+// Sketch modes, see -mode.
+const (
+	modeSliding = "sliding" // sliding window counts, using sliding.Sketch
+	modeDecayed = "decayed" // exponentially decayed counts, using topk.Sketch
+)
+
+// decayedHistoryLength is the number of ticks of decayed counts kept for plotting the top-K items.
+const decayedHistoryLength = maxPlotLength
+
+// decayedSketch is a top-K sketch whose counts are multiplied by 2^(-1/h) each tick, for a half-life of h ticks.
+// Instead of a per-tick history of all buckets, it only keeps the last decayedHistoryLength counts of its heap items.
+type decayedSketch struct {
+	*topk.Sketch
+	factor  float64             // decay factor per tick
+	history map[string][]uint32 // recent counts of the heap items, oldest first
+}
+
+func newDecayedSketch() *decayedSketch {
+	return &decayedSketch{
+		Sketch:  topk.New(sketchK(), decayedSketchOptions(shardWidth())...),
+		factor:  decayFactor(),
+		history: make(map[string][]uint32),
+	}
+}
+
+// decayFactor returns the factor that decayed counts are multiplied by each tick, for the -half-life.
+func decayFactor() float64 {
+	return math.Exp2(-float64(config.TickSize) / float64(config.HalfLife))
+}
+
+func decayedSketchOptions(width int) []topk.Option {
+	return []topk.Option{
+		topk.WithWidth(width),
+		topk.WithDepth(config.Depth),
+		topk.WithDecay(float32(config.Decay)),
+		topk.WithDecayLUTSize(config.DecayLUTSize),
+	}
+}
+
+// meanLifetime returns the mean lifetime of a count for the -half-life, i.e. the length of the sliding window
+// whose counts a steady stream's decayed counts match.
+func meanLifetime() time.Duration {
+	return max(config.TickSize, time.Duration(float64(config.HalfLife)/math.Ln2).Truncate(config.TickSize))
+}
+
+// Ticks decays the counts by n ticks, and records the heap items' counts in their history.
+func (d *decayedSketch) Ticks(n int) {
+	f := math.Pow(d.factor, float64(n))
+	for i := range d.Buckets {
+		b := &d.Buckets[i]
+		b.Count = decayCount(b.Count, f)
+	}
+	for i := range d.Heap.Items {
+		item := &d.Heap.Items[i]
+		var count uint32
+		for k := range d.Depth {
+			b := &d.Buckets[topk.BucketIndex(item.Item, k, d.Width)]
+			if b.Fingerprint == item.Fingerprint {
+				count = max(count, b.Count)
+			}
+		}
+		item.Count = count
+	}
+	d.Heap.Reinit() // drops the items decayed to 0
+
+	for item := range d.history {
+		if !d.Heap.Contains(item) {
+			delete(d.history, item)
+		}
+	}
+	for _, item := range d.Heap.Items {
+		history := d.history[item.Item]
+		for range min(n, decayedHistoryLength) {
+			history = append(history, item.Count)
+		}
+		if len(history) > decayedHistoryLength {
+			history = append(history[:0], history[len(history)-decayedHistoryLength:]...)
+		}
+		d.history[item.Item] = history
+	}
+}
+
+// decayCount multiplies the count by f, rounding up or down at random so that the result is unbiased.
+func decayCount(count uint32, f float64) uint32 {
+	v := float64(count) * f
+	n := math.Floor(v)
+	if rand.Float64() < v-n {
+		n++
+	}
+	return uint32(n)
+}
+
+// countHistory fills the series (oldest first) with the item's recorded counts, padding with zeros.
+func (d *decayedSketch) countHistory(item string, series []float64) {
+	history := d.history[item]
+	for i := range series {
+		series[i] = 0
+		if j := i - (len(series) - len(history)); j >= 0 {
+			series[i] = float64(history[j])
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestDecayedSketchTicks(t *testing.T) {
+	testModel(t, func(c *Config) { c.HalfLife = c.TickSize })
+	d := newDecayedSketch()
+	d.Add("a", 1000)
+	d.Add("b", 1)
+	d.Ticks(1)
+	if got := len(d.history["a"]); got != 1 {
+		t.Fatalf("got %d history entries for a after 1 tick, want 1", got)
+	}
+
+	d.Ticks(64) // decays any count to 0
+	if got := d.Heap.Len(); got != 0 {
+		t.Errorf("got %d heap items after decaying to 0, want none: %v", got, d.Heap.Items)
+	}
+	if d.Heap.Contains("a") {
+		t.Error("a is still indexed in the heap after decaying to 0")
+	}
+	if len(d.history) != 0 {
+		t.Errorf("got history %v after decaying to 0, want none", d.history)
+	}
+}
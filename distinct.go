@@ -0,0 +1,174 @@
+package main
+
+import (
+	"hash/maphash"
+	"math"
+	"math/bits"
+
+	"github.com/keilerkonzept/topk/heap"
+)
+
+const (
+	// hllPrecision is the number of hash bits selecting a HyperLogLog register, for a standard error of about 1.04/sqrt(2^p).
+	hllPrecision = 8
+	hllRegisters = 1 << hllPrecision
+
+	// windowSegments is the (maximum) number of sub-windows that the per-item distinct counts and quantiles
+	// of each window are split into. They slide by one segment at a time, and cover between the window minus
+	// one segment and the window.
+	windowSegments = 8
+)
+
+// segmentClock tracks the latest segment of a window split into windowSegments segments.
+type segmentClock struct {
+	segments     int // number of segments in use
+	current      int // index of the latest segment
+	segmentTicks int // ticks per segment
+	ticks        int // ticks since the latest segment started
+}
+
+func newSegmentClock(windowSize int) segmentClock {
+	segments := max(1, min(windowSize, windowSegments))
+	return segmentClock{segments: segments, segmentTicks: max(1, windowSize/segments)}
+}
+
+// advance advances the clock by n ticks, calling start for each segment that starts, in order.
+// It returns whether any segment started.
+func (c *segmentClock) advance(n int, start func(segment int)) bool {
+	c.ticks += n
+	segments := min(c.ticks/c.segmentTicks, c.segments)
+	if segments == 0 {
+		return false
+	}
+	c.ticks %= c.segmentTicks
+	for range segments {
+		c.current = (c.current + 1) % c.segments
+		start(c.current)
+	}
+	return true
+}
+
+var distinctSeed = maphash.MakeSeed()
+
+// distinctHash returns the hash of a -distinct field value, or 0 for empty values.
+func distinctHash(value string) uint64 {
+	if value == "" {
+		return 0
+	}
+	return maphash.String(distinctSeed, value) | 1
+}
+
+// hll is a HyperLogLog with one set of registers per segment of the window.
+type hll [windowSegments][hllRegisters]uint8
+
+func (h *hll) add(segment int, hash uint64) {
+	r := &h[segment]
+	i := hash >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(hash<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	r[i] = max(r[i], rank)
+}
+
+// estimate returns the estimated number of distinct values over all segments.
+func (h *hll) estimate() float64 {
+	var (
+		sum   float64
+		zeros int
+	)
+	for i := range hllRegisters {
+		var rank uint8
+		for s := range h {
+			rank = max(rank, h[s][i])
+		}
+		if rank == 0 {
+			zeros++
+		}
+		sum += math.Ldexp(1, -int(rank))
+	}
+	const m = hllRegisters
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 { // small range correction (linear counting)
+		estimate = m * math.Log(float64(m)/float64(zeros))
+	}
+	return estimate
+}
+
+// distinctCounter estimates the number of distinct -distinct field values of each item in a sketch's top-K heap.
+type distinctCounter struct {
+	items map[string]*hll
+	clock segmentClock
+}
+
+func newDistinctCounter(windowSize int) *distinctCounter {
+	return &distinctCounter{
+		items: make(map[string]*hll),
+		clock: newSegmentClock(windowSize),
+	}
+}
+
+// Add records the value hash for the item, if the item is in the top-K heap.
+func (d *distinctCounter) Add(top *heap.Min, item string, hash uint64) {
+	h, ok := d.items[item]
+	if !ok {
+		if !top.Contains(item) {
+			return
+		}
+		h = new(hll)
+		d.items[item] = h
+	}
+	h.add(d.clock.current, hash)
+}
+
+// Ticks advances the segments, and stops tracking items that are no longer in the top-K heap.
+func (d *distinctCounter) Ticks(top *heap.Min, n int) {
+	started := d.clock.advance(n, func(segment int) {
+		for _, h := range d.items {
+			clear(h[segment][:])
+		}
+	})
+	if !started {
+		return
+	}
+	for item := range d.items {
+		if !top.Contains(item) {
+			delete(d.items, item)
+		}
+	}
+}
+
+// Count returns the estimated number of distinct values of the item, and whether the item is tracked.
+func (d *distinctCounter) Count(item string) (float64, bool) {
+	h, ok := d.items[item]
+	if !ok {
+		return 0, false
+	}
+	return h.estimate(), true
+}
+
+// distinctCounts returns the estimated numbers of distinct values of the items in the given window
+// (NaN for untracked items), or nil without -distinct.
+func (m *model) distinctCounts(window int, items []heap.Item) []float64 {
+	if config.Distinct == "" {
+		return nil
+	}
+	counts := make([]float64, len(items))
+	for i, item := range items {
+		sh := m.shards[shardIndex(item.Item, len(m.shards))]
+		sh.mu.Lock()
+		count, ok := sh.distinct[window].Count(item.Item)
+		sh.mu.Unlock()
+		if !ok {
+			count = math.NaN()
+		}
+		counts[i] = count
+	}
+	return counts
+}
+
+// formatDistinct formats an item's distinct count, e.g. client:1.2k.
+func formatDistinct(count float64) string {
+	if math.IsNaN(count) {
+		return config.Distinct + ":?"
+	}
+	return config.Distinct + ":" + formatSI(math.Round(count))
+}
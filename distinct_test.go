@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/keilerkonzept/topk/heap"
+)
+
+func TestHLLEstimate(t *testing.T) {
+	// four standard errors of 1.04/sqrt(2^p)
+	maxError := 4 * 1.04 / math.Sqrt(hllRegisters)
+	for _, n := range []int{1, 10, 100, 1000, 10_000, 100_000} {
+		var h hll
+		for i := range n {
+			// spread over the segments, and add each value twice
+			hash := distinctHash(fmt.Sprint("value-", i))
+			h.add(i%windowSegments, hash)
+			h.add((i+1)%windowSegments, hash)
+		}
+		if got := h.estimate(); math.Abs(got-float64(n))/float64(n) > maxError {
+			t.Errorf("%d distinct values: got estimate %.1f, want within %.0f%%", n, got, 100*maxError)
+		}
+	}
+	var h hll
+	if got := h.estimate(); got != 0 {
+		t.Errorf("no values: got estimate %v, want 0", got)
+	}
+}
+
+func TestSegmentClock(t *testing.T) {
+	tests := []struct {
+		windowSize   int
+		ticks        []int
+		wantSegments int
+		wantStarted  [][]int // segments started by each advance
+	}{
+		{16, []int{1, 1, 5, 1}, 8, [][]int{nil, {1}, {2, 3}, {4}}},
+		{16, []int{3, 2}, 8, [][]int{{1}, {2}}},
+		{16, []int{100}, 8, [][]int{{1, 2, 3, 4, 5, 6, 7, 0}}},
+		{3, []int{1, 2, 1}, 3, [][]int{{1}, {2, 0}, {1}}},
+		{0, []int{1}, 1, [][]int{{0}}},
+	}
+	for _, tt := range tests {
+		c := newSegmentClock(tt.windowSize)
+		if c.segments != tt.wantSegments {
+			t.Errorf("window %d: got %d segments, want %d", tt.windowSize, c.segments, tt.wantSegments)
+		}
+		for i, n := range tt.ticks {
+			var started []int
+			ok := c.advance(n, func(segment int) { started = append(started, segment) })
+			if !reflect.DeepEqual(started, tt.wantStarted[i]) || ok != (started != nil) {
+				t.Errorf("window %d, advance %d by %d: got started %v (%v), want %v", tt.windowSize, i, n, started, ok, tt.wantStarted[i])
+			}
+		}
+	}
+}
+
+func TestDistinctCounter(t *testing.T) {
+	top := heap.NewMin(2)
+	top.Update("a", 0, 10)
+	d := newDistinctCounter(16) // 8 segments of 2 ticks
+
+	d.Add(top, "b", distinctHash("x")) // not in the heap
+	if _, ok := d.Count("b"); ok {
+		t.Errorf("got b tracked before it entered the heap")
+	}
+	var all hll // the same values in one segment
+	for i := range 10 {
+		hash := distinctHash(fmt.Sprint(i))
+		d.Add(top, "a", hash)
+		d.Ticks(top, 1)
+		all.add(0, hash)
+	}
+	d.Add(top, "a", distinctHash("0")) // a duplicate, in the sixth segment
+	if got, ok := d.Count("a"); !ok || got != all.estimate() {
+		t.Errorf("got distinct count %v (%v) for a, want %v", got, ok, all.estimate())
+	}
+	d.Ticks(top, 14) // clears all segments but the duplicate's
+	if got, _ := d.Count("a"); math.Round(got) != 1 {
+		t.Errorf("got distinct count %v for a after 14 ticks, want 1", got)
+	}
+	d.Ticks(top, 2)
+	if got, _ := d.Count("a"); got != 0 {
+		t.Errorf("got distinct count %v for a after a whole window, want 0", got)
+	}
+
+	top.Update("b", 0, 20)
+	top.Update("c", 0, 30) // replaces a
+	d.Ticks(top, 1)
+	if _, ok := d.Count("a"); !ok {
+		t.Errorf("got a untracked before a segment started")
+	}
+	d.Ticks(top, 1)
+	if _, ok := d.Count("a"); ok {
+		t.Errorf("got a tracked after it left the heap")
+	}
+}
+
+func TestDistinctCounts(t *testing.T) {
+	m := testModel(t, func(c *Config) {
+		c.Shards = 1
+		c.Windows = []time.Duration{4 * c.TickSize}
+		c.Distinct = "client"
+	})
+	m.add(record{Item: "a", Count: 5})
+	m.add(record{Item: "b", Count: 3, Distinct: "x"})
+	m.add(record{Item: "b", Count: 1, Distinct: "x"})
+	flushQueues(m)
+
+	items := []heap.Item{{Item: "a"}, {Item: "b"}}
+	counts := m.distinctCounts(0, items)
+	var got []string
+	for _, count := range counts {
+		got = append(got, formatDistinct(count))
+	}
+	// a entered the heap without a client value, so it is not tracked yet
+	if want := []string{"client:?", "client:1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"net/netip"
+	"strings"
+
+	"github.com/keilerkonzept/topk/heap"
+)
+
+const (
+	hierarchyIP   = "ip"
+	hierarchyPath = "path"
+)
+
+// Prefix lengths of the inner levels of the IP hierarchy. The leaves are the addresses themselves.
+var (
+	hierarchyIPv4Bits = []int{8, 16, 24}
+	hierarchyIPv6Bits = []int{32, 48, 64}
+)
+
+// sketchK returns the number of items tracked by each sketch: k, or with -hierarchy, k per level of the hierarchy,
+// and with sliding windows, changeCandidates times as many as candidates for the risers and fallers views.
+func sketchK() int {
+	k := config.K
+	switch config.Hierarchy {
+	case hierarchyIP:
+		k *= len(hierarchyIPv4Bits) + 1
+	case hierarchyPath:
+		k *= config.HierarchyDepth + 1
+	}
+	if config.Mode == modeSliding {
+		k *= changeCandidates
+	}
+	return k
+}
+
+// hierarchyKeys returns the keys the item is counted under: its prefixes, from the coarsest one, and the item itself.
+func hierarchyKeys(item string) []string {
+	var keys []string
+	for key := parentKey(item); key != ""; key = parentKey(key) {
+		keys = append(keys, key)
+	}
+	for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+		keys[i], keys[j] = keys[j], keys[i]
+	}
+	return append(keys, item)
+}
+
+// parentKey returns the key of the next coarser level of the hierarchy, or "" for top-level keys.
+func parentKey(key string) string {
+	switch config.Hierarchy {
+	case hierarchyIP:
+		return ipParent(key)
+	case hierarchyPath:
+		return pathParent(key)
+	}
+	return ""
+}
+
+// ipParent returns the parent prefix of an address (e.g. 10.1.2.0/24 for 10.1.2.3) or of a prefix
+// (e.g. 10.1.0.0/16 for 10.1.2.0/24), or "" for top-level prefixes and other keys.
+func ipParent(key string) string {
+	var (
+		addr netip.Addr
+		bits int
+	)
+	if prefix, err := netip.ParsePrefix(key); err == nil {
+		addr, bits = prefix.Addr(), prefix.Bits()
+	} else if addr, err = netip.ParseAddr(key); err == nil {
+		addr = addr.Unmap()
+		bits = addr.BitLen()
+	} else {
+		return ""
+	}
+	levels := hierarchyIPv4Bits
+	if addr.Is6() {
+		levels = hierarchyIPv6Bits
+	}
+	for i := len(levels) - 1; i >= 0; i-- {
+		if levels[i] < bits {
+			parent, _ := addr.Prefix(levels[i])
+			return parent.String()
+		}
+	}
+	return ""
+}
+
+// pathPrefixSuffix ends the path prefixes, e.g. /a/b/… for the paths below /a/b/. Request paths are percent-encoded,
+// so a path itself cannot end with it, and a path ending with a slash (e.g. /a/b/) is a child of its prefix.
+const pathPrefixSuffix = "/…"
+
+// pathParent returns the parent prefix of a URL path (e.g. /a/b/… for /a/b/c?q) or of a path prefix
+// (e.g. /a/… for /a/b/…), up to -hierarchy-depth segments deep, or "" for top-level prefixes and other keys.
+func pathParent(key string) string {
+	if !strings.HasPrefix(key, "/") {
+		return ""
+	}
+	var (
+		segments []string
+		depth    int
+	)
+	if prefix, ok := strings.CutSuffix(key, pathPrefixSuffix); ok {
+		segments = strings.Split(strings.TrimPrefix(prefix, "/"), "/")
+		depth = len(segments) - 1
+	} else {
+		path, _, _ := strings.Cut(key, "?")
+		path, _, _ = strings.Cut(path, "#")
+		segments = strings.Split(path[1:], "/")
+		depth = min(len(segments)-1, config.HierarchyDepth)
+	}
+	if depth < 1 {
+		return ""
+	}
+	return "/" + strings.Join(segments[:depth], "/") + pathPrefixSuffix
+}
+
+// treeNode is the position of a list item in the hierarchy's tree view.
+type treeNode struct {
+	Level       int
+	HasChildren bool
+	Expanded    bool
+}
+
+// hierarchyItems returns the tree view of the shards' top-K items of the given window: the top-K top-level keys,
+// each followed by its children (if it is expanded), in order of descending count.
+// Keys whose parent is not among the items are shown at the top level.
+func (m *model) hierarchyItems(window int) ([]heap.Item, []treeNode) {
+	var all []heap.Item
+	for _, sh := range m.shards {
+		sh.mu.Lock()
+		for _, item := range sh.items(window) {
+			if item.Count > 0 {
+				all = append(all, item)
+			}
+		}
+		sh.mu.Unlock()
+	}
+	present := make(map[string]bool, len(all))
+	for _, item := range all {
+		present[item.Item] = true
+	}
+	children := make(map[string][]heap.Item)
+	for _, item := range topItems(all, len(all)) {
+		parent := parentKey(item.Item)
+		if !present[parent] {
+			parent = ""
+		}
+		children[parent] = append(children[parent], item)
+	}
+	var (
+		items []heap.Item
+		nodes []treeNode
+		walk  func(level int, siblings []heap.Item)
+	)
+	walk = func(level int, siblings []heap.Item) {
+		for _, item := range siblings {
+			node := treeNode{
+				Level:       level,
+				HasChildren: len(children[item.Item]) > 0,
+				Expanded:    m.expanded[item.Item],
+			}
+			items, nodes = append(items, item), append(nodes, node)
+			if node.HasChildren && node.Expanded {
+				walk(level+1, children[item.Item])
+			}
+		}
+	}
+	roots := children[""]
+	walk(0, roots[:min(len(roots), config.K)])
+	return items, nodes
+}
+
+// expandSelected expands the selected tree node.
+func (m *model) expandSelected() {
+	m.mu.Lock()
+	i := m.list.Index()
+	if i < len(m.listTree) && m.listTree[i].HasChildren {
+		m.expanded[m.listItems[i].Item] = true
+	}
+	m.mu.Unlock()
+	m.updateTopK()
+}
+
+// collapseSelected collapses the selected tree node if it is expanded, and otherwise its parent.
+// It returns the collapsed parent's key, if any.
+func (m *model) collapseSelected() string {
+	m.mu.Lock()
+	i := m.list.Index()
+	var parent string
+	if i < len(m.listTree) {
+		item := m.listItems[i].Item
+		if m.listTree[i].Expanded && m.listTree[i].HasChildren {
+			delete(m.expanded, item)
+		} else if m.listTree[i].Level > 0 {
+			parent = parentKey(item)
+			delete(m.expanded, parent)
+		}
+	}
+	m.mu.Unlock()
+	m.updateTopK()
+	return parent
+}
+
+// selectItem selects the list item with the given key, if it is listed.
+func (m *model) selectItem(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, item := range m.listItems {
+		if item.Item == key {
+			m.list.Select(i)
+			return
+		}
+	}
+}
+
+// treeTitle returns the list title of a tree node, indented by its level, with an expansion marker.
+func treeTitle(node treeNode, item string) string {
+	marker := "  "
+	if node.HasChildren {
+		marker = "▸ "
+		if node.Expanded {
+			marker = "▾ "
+		}
+	}
+	return strings.Repeat("  ", node.Level) + marker + item
+}
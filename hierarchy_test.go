@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIPParent(t *testing.T) {
+	tests := []struct {
+		key, want string
+	}{
+		{"10.1.2.3", "10.1.2.0/24"},
+		{"10.1.2.0/24", "10.1.0.0/16"},
+		{"10.1.0.0/16", "10.0.0.0/8"},
+		{"10.0.0.0/8", ""},
+		{"10.1.2.3/20", "10.1.0.0/16"},
+		{"::ffff:10.1.2.3", "10.1.2.0/24"},
+		{"2001:db8:1:2::1", "2001:db8:1:2::/64"},
+		{"2001:db8:1:2::/64", "2001:db8:1::/48"},
+		{"2001:db8:1::/48", "2001:db8::/32"},
+		{"2001:db8::/32", ""},
+		{"fe80::1%eth0", "fe80::/64"},
+		{"example.com", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := ipParent(tt.key); got != tt.want {
+			t.Errorf("ipParent(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestPathParent(t *testing.T) {
+	tests := []struct {
+		key   string
+		depth int
+		want  string
+	}{
+		{"/api/v1/users/42", 3, "/api/v1/users/…"},
+		{"/api/v1/users/42?id=7/8", 3, "/api/v1/users/…"},
+		{"/api/v1/users/42#a/b", 3, "/api/v1/users/…"},
+		{"/api/v1/users/", 3, "/api/v1/users/…"},
+		{"/api/v1/users/…", 3, "/api/v1/…"},
+		{"/api/v1/…", 3, "/api/…"},
+		{"/api/…", 3, ""},
+		{"/a/b/c/d/e", 3, "/a/b/c/…"},
+		{"/a/b/c/d/e", 1, "/a/…"},
+		{"/a/b/c/…", 1, "/a/b/…"}, // prefixes of a deeper -hierarchy-depth still roll up
+		{"/api", 3, ""},
+		{"/", 3, ""},
+		{"/?q=/a/b", 3, ""},
+		{"/…", 3, ""},
+		{"api/v1", 3, ""},
+		{"GET /api/v1", 3, ""},
+	}
+	for _, tt := range tests {
+		testModel(t, func(c *Config) { c.HierarchyDepth = tt.depth })
+		if got := pathParent(tt.key); got != tt.want {
+			t.Errorf("pathParent(%q) with depth %d = %q, want %q", tt.key, tt.depth, got, tt.want)
+		}
+	}
+}
+
+func TestHierarchyKeys(t *testing.T) {
+	tests := []struct {
+		hierarchy string
+		depth     int
+		item      string
+		want      []string
+	}{
+		{hierarchyIP, 3, "10.1.2.3", []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24", "10.1.2.3"}},
+		{hierarchyIP, 3, "2001:db8:1:2::1", []string{"2001:db8::/32", "2001:db8:1::/48", "2001:db8:1:2::/64", "2001:db8:1:2::1"}},
+		{hierarchyIP, 3, "not an address", []string{"not an address"}},
+		{hierarchyPath, 3, "/api/v1/users/42?x=1", []string{"/api/…", "/api/v1/…", "/api/v1/users/…", "/api/v1/users/42?x=1"}},
+		{hierarchyPath, 2, "/api/v1/users/42", []string{"/api/…", "/api/v1/…", "/api/v1/users/42"}},
+		{hierarchyPath, 3, "/api/v1/", []string{"/api/…", "/api/v1/…", "/api/v1/"}},
+		{hierarchyPath, 3, "/", []string{"/"}},
+		{hierarchyPath, 3, "-", []string{"-"}},
+		{"", 3, "/api/v1", []string{"/api/v1"}},
+	}
+	for _, tt := range tests {
+		testModel(t, func(c *Config) {
+			c.Hierarchy = tt.hierarchy
+			c.HierarchyDepth = tt.depth
+		})
+		if got := hierarchyKeys(tt.item); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("hierarchyKeys(%q) with -hierarchy=%s and depth %d = %q, want %q", tt.item, tt.hierarchy, tt.depth, got, tt.want)
+		}
+	}
+}
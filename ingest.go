@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	tui "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	overflowBlock      = "block"
+	overflowDropNewest = "drop-newest"
+	overflowSample     = "sample"
+)
+
+// ingestBatchSize is the maximum number of queued operations applied under one lock acquisition.
+const ingestBatchSize = 4096
+
+// ingestOp is a queued sketch update: either an item to count, a number of ticks to advance by,
+// or a snapshot to add the top-K items to.
+type ingestOp struct {
+	item     string
+	count    uint32
+	distinct uint64  // hash of the -distinct field value, if any
+	value    float64 // -value field value, if hasValue
+	hasValue bool
+	ticks    int
+	snapshot *pendingSnapshot
+}
+
+// add counts the record in the stream totals, and queues it, see queueRecord.
+func (m *model) add(r record) {
+	m.totals.Add(1, uint64(m.queueRecord(r)))
+}
+
+// queueRecord queues the record's item for counting, or with -hierarchy, the item and its prefixes,
+// and returns its count. Missing, zero and negative counts count as 1.
+func (m *model) queueRecord(r record) uint32 {
+	count := uint32(min(max(1, r.Count), math.MaxUint32))
+	op := ingestOp{
+		item:     r.Item,
+		count:    count,
+		distinct: distinctHash(r.Distinct),
+		value:    r.Value,
+		hasValue: r.HasValue,
+	}
+	if config.Hierarchy == "" {
+		m.enqueue(op)
+		return count
+	}
+	for _, key := range hierarchyKeys(r.Item) {
+		op.item = key
+		m.enqueue(op)
+	}
+	return count
+}
+
+// enqueue queues the item for counting in its shard, applying the -queue-overflow policy if the shard's queue is full.
+func (m *model) enqueue(op ingestOp) {
+	sh := m.shards[shardIndex(op.item, len(m.shards))]
+	select {
+	case sh.queue <- op:
+		return
+	default:
+	}
+	switch config.QueueOverflow {
+	case overflowDropNewest:
+		sh.dropped.Add(1)
+	case overflowSample:
+		// keep every n-th record while the queue is full, with its count scaled up by n (waiting for room to queue it)
+		n := uint64(config.QueueSample)
+		if sh.overflowed.Add(1)%n != 0 {
+			sh.dropped.Add(1)
+			return
+		}
+		op.count = uint32(min(uint64(op.count)*n, math.MaxUint32))
+		sh.queue <- op
+	default:
+		sh.queue <- op
+	}
+}
+
+// queueTicks queues the ticks for all shards, and advances the stream totals. Ticks are never dropped.
+func (m *model) queueTicks(ticks int) {
+	m.totals.Ticks(ticks)
+	for _, sh := range m.shards {
+		sh.queue <- ingestOp{ticks: ticks}
+	}
+}
+
+// applyQueuedCmd applies the queued operations of all shards, one goroutine per shard.
+func (m *model) applyQueuedCmd() tui.Cmd {
+	return func() tui.Msg {
+		for _, sh := range m.shards[1:] {
+			go m.applyQueued(sh)
+		}
+		m.applyQueued(m.shards[0])
+		return nil
+	}
+}
+
+// applyQueued applies the shard's queued operations in batches, each under one lock acquisition.
+// Within a batch, the counts of duplicate items are summed up before they are added to the sketches.
+func (m *model) applyQueued(sh *shard) {
+	counts := make(map[string]uint32)
+	var (
+		extra   []ingestOp // records with a -distinct or -value field, added after their items are counted
+		records uint64
+	)
+	flush := func() {
+		for item, count := range counts {
+			for _, s := range sh.sketches {
+				s.Add(item, count)
+			}
+			if sh.decayed != nil {
+				sh.decayed.Add(item, count)
+			}
+			if sh.exact != nil {
+				sh.exact.Add(item, count)
+			}
+			if sh.auto != nil {
+				sh.auto.Add(item, count)
+			}
+			if sh.newItems != nil {
+				sh.newItems.Add(item)
+			}
+		}
+		clear(counts)
+		for _, op := range extra {
+			for w := range config.Windows {
+				if op.distinct != 0 && sh.distinct != nil {
+					sh.distinct[w].Add(sh.heap(w), op.item, op.distinct)
+				}
+				if op.hasValue && sh.quantiles != nil {
+					sh.quantiles[w].Add(sh.heap(w), op.item, op.value, op.count)
+				}
+			}
+		}
+		extra = extra[:0]
+	}
+	for op := range sh.queue {
+		sh.mu.Lock()
+		for n := 0; ; n++ {
+			if op.snapshot != nil {
+				flush()
+				op.snapshot.add(sh, m.archive)
+			} else if op.ticks > 0 {
+				flush()
+				for _, s := range sh.sketches {
+					s.Ticks(min(op.ticks, s.WindowSize))
+				}
+				if sh.decayed != nil {
+					sh.decayed.Ticks(op.ticks)
+				}
+				for w := range config.Windows {
+					if sh.distinct != nil {
+						sh.distinct[w].Ticks(sh.heap(w), op.ticks)
+					}
+					if sh.quantiles != nil {
+						sh.quantiles[w].Ticks(sh.heap(w), op.ticks)
+					}
+				}
+				if sh.exact != nil {
+					sh.exact.Ticks(op.ticks)
+				}
+				if sh.auto != nil {
+					sh.auto.Ticks(op.ticks)
+				}
+				if sh.newItems != nil {
+					sh.newItems.Ticks(op.ticks)
+				}
+			} else {
+				counts[op.item] += op.count
+				if (op.distinct != 0 && sh.distinct != nil) || (op.hasValue && sh.quantiles != nil) {
+					extra = append(extra, op)
+				}
+				records++
+			}
+			if n == ingestBatchSize {
+				break
+			}
+			var ok bool
+			select {
+			case op, ok = <-sh.queue:
+			default:
+			}
+			if !ok {
+				break
+			}
+		}
+		flush()
+		sh.mu.Unlock()
+		m.ingested.Add(records)
+		records = 0
+	}
+}
+
+func (m *model) queueStatus() string {
+	var depth, capacity int
+	var dropped uint64
+	for _, sh := range m.shards {
+		depth += len(sh.queue)
+		capacity += cap(sh.queue)
+		dropped += sh.dropped.Load()
+	}
+	status := fmt.Sprintf("queue %s/%s", formatSI(float64(depth)), formatSI(float64(capacity)))
+	if config.QueueOverflow != overflowBlock {
+		status += fmt.Sprintf(" dropped %s (%s)", formatSI(float64(dropped)), config.QueueOverflow)
+	}
+	return status
+}
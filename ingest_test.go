@@ -0,0 +1,139 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/keilerkonzept/topk"
+	"github.com/keilerkonzept/topk/heap"
+)
+
+// receiveOps receives n operations from the shard's queue.
+func receiveOps(t *testing.T, sh *shard, n int) []ingestOp {
+	t.Helper()
+	var ops []ingestOp
+	for range n {
+		select {
+		case op := <-sh.queue:
+			ops = append(ops, op)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for queued operation %d", len(ops)+1)
+		}
+	}
+	return ops
+}
+
+func TestQueueOverflow(t *testing.T) {
+	tests := []struct {
+		policy  string
+		records []record
+		queued  []ingestOp
+		waits   []uint64 // before receiving each queued operation, wait for this many overflowed and dropped records
+		dropped uint64
+	}{
+		{
+			policy:  overflowBlock,
+			records: []record{{Item: "a"}, {Item: "b"}, {Item: "c"}},
+			queued:  []ingestOp{{item: "a", count: 1}, {item: "b", count: 1}, {item: "c", count: 1}},
+			waits:   []uint64{0, 0, 0},
+		},
+		{
+			policy:  overflowDropNewest,
+			records: []record{{Item: "a"}, {Item: "b"}, {Item: "c"}, {Item: "d"}},
+			queued:  []ingestOp{{item: "a", count: 1}, {item: "b", count: 1}},
+			waits:   []uint64{2, 2},
+			dropped: 2,
+		},
+		{
+			policy: overflowSample,
+			records: []record{
+				{Item: "a"}, {Item: "b"}, // fill the queue
+				{Item: "c", Count: 5}, {Item: "d"}, {Item: "e", Count: 2}, // every third record is kept, counted 3 times
+				{Item: "f"}, {Item: "g"}, {Item: "h", Count: math.MaxUint32 / 2}, // saturated
+			},
+			queued: []ingestOp{{item: "a", count: 1}, {item: "b", count: 1}, {item: "e", count: 6}, {item: "h", count: math.MaxUint32}},
+			// e waits for room after 3 overflowed and 2 dropped records, h after 6 and 4
+			waits:   []uint64{5, 10, 10, 10},
+			dropped: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			m := testModel(t, func(c *Config) {
+				c.Shards = 1
+				c.QueueSize = 2
+				c.QueueOverflow = tt.policy
+				c.QueueSample = 3
+			})
+			sh := m.shards[0]
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for _, r := range tt.records {
+					m.add(r)
+				}
+			}()
+			var queued []ingestOp
+			for _, wait := range tt.waits {
+				for deadline := time.Now().Add(5 * time.Second); sh.overflowed.Load()+sh.dropped.Load() < wait; {
+					if time.Now().After(deadline) {
+						t.Fatalf("timed out waiting for %d overflowed and dropped records", wait)
+					}
+					time.Sleep(time.Millisecond)
+				}
+				queued = append(queued, receiveOps(t, sh, 1)...)
+			}
+			<-done
+			if !reflect.DeepEqual(queued, tt.queued) {
+				t.Errorf("got queued %+v, want %+v", queued, tt.queued)
+			}
+			if got := sh.dropped.Load(); got != tt.dropped {
+				t.Errorf("got %d dropped, want %d", got, tt.dropped)
+			}
+			if total, _ := m.totals.Window(windowTicks()[0]); total.Events != uint64(len(tt.records)) {
+				t.Errorf("got %d records in the stream totals, want all %d", total.Events, len(tt.records))
+			}
+		})
+	}
+}
+
+func TestApplyQueued(t *testing.T) {
+	m := testModel(t, func(c *Config) {
+		c.Shards = 1
+		c.Windows = []time.Duration{4 * c.TickSize}
+		c.ShadowExact = true
+	})
+	for _, count := range []int{1, 2, 3} {
+		m.add(record{Item: "a", Count: count})
+	}
+	m.add(record{Item: "b", Count: 4})
+	m.queueTicks(1) // flushes the summed counts before ticking
+	m.add(record{Item: "a", Count: 5})
+	m.add(record{Item: "a", Count: 0})
+	flushQueues(m)
+
+	sh := m.shards[0]
+	tests := []struct {
+		item   string
+		counts []float64 // by tick age
+	}{
+		{"a", []float64{6, 6, 0, 0}},
+		{"b", []float64{0, 4, 0, 0}},
+	}
+	for _, tt := range tests {
+		item := heap.Item{Item: tt.item, Fingerprint: topk.Fingerprint(tt.item)}
+		for age, want := range tt.counts {
+			if got := countBetween(sh.sketches[0], item, float64(age), float64(age+1)); got != want {
+				t.Errorf("%s at age %d: got %v, want %v", tt.item, age, got, want)
+			}
+		}
+	}
+	if got := sh.exact.Count(0, "a"); got != 12 {
+		t.Errorf("got exact count %d for a, want 12", got)
+	}
+	if got := m.ingested.Load(); got != 6 {
+		t.Errorf("got %d ingested records, want 6", got)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+)
+
+const journalRealtimeTimestamp = "__REALTIME_TIMESTAMP"
+
+func (m *model) readJournalExportItems() {
+	r := newJournalExportReader(bufio.NewReader(m.input))
+	var last time.Time
+	for {
+		entry, err := r.Next()
+		if err != nil {
+			return
+		}
+		item, ok := entry[config.JournalField]
+		if !ok {
+			continue
+		}
+		var timestamp any
+		if usec, err := strconv.ParseInt(entry[journalRealtimeTimestamp], 10, 64); err == nil {
+			timestamp = time.UnixMicro(usec)
+		}
+		value, err := strconv.ParseFloat(entry[config.Value], 64)
+		last = m.countRecord(record{
+			Item:      item,
+			Count:     1,
+			Timestamp: timestamp,
+			Distinct:  entry[config.Distinct],
+			Value:     value,
+			HasValue:  err == nil,
+		}, last)
+	}
+}
+
+// journalMaxFieldSize is the maximum size of a binary field value.
+const journalMaxFieldSize = 64 << 20
+
+var errJournalInvalid = errors.New("journal export: invalid data")
+
+// journalExportReader reads entries in the journal export format (`journalctl -o export`),
+// see https://systemd.io/JOURNAL_EXPORT_FORMATS/
+type journalExportReader struct {
+	r *bufio.Reader
+}
+
+func newJournalExportReader(r *bufio.Reader) *journalExportReader {
+	return &journalExportReader{r: r}
+}
+
+// Next returns the fields of the next entry.
+func (j *journalExportReader) Next() (map[string]string, error) {
+	entry := make(map[string]string)
+	for {
+		line, err := j.r.ReadBytes('\n')
+		if err == io.EOF && len(line) > 0 { // last line without trailing newline
+			line, err = append(line, '\n'), nil
+		}
+		if err != nil {
+			if err == io.EOF && len(entry) > 0 {
+				return entry, nil
+			}
+			return nil, err
+		}
+		line = line[:len(line)-1]
+		if len(line) == 0 {
+			if len(entry) == 0 {
+				continue
+			}
+			return entry, nil
+		}
+		if name, value, ok := bytes.Cut(line, []byte{'='}); ok {
+			entry[string(name)] = string(value)
+			continue
+		}
+
+		// binary field: name, newline, little-endian uint64 size, data, newline
+		var size [8]byte
+		if _, err := io.ReadFull(j.r, size[:]); err != nil {
+			return nil, noEOF(err)
+		}
+		n := binary.LittleEndian.Uint64(size[:])
+		if n > journalMaxFieldSize {
+			return nil, errJournalInvalid
+		}
+		value := make([]byte, n+1)
+		if _, err := io.ReadFull(j.r, value); err != nil {
+			return nil, noEOF(err)
+		}
+		if value[n] != '\n' {
+			return nil, errJournalInvalid
+		}
+		entry[string(line)] = string(value[:n])
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// journalBinaryField encodes a field in the binary serialization of the journal export format.
+func journalBinaryField(name, value string) string {
+	size := binary.LittleEndian.AppendUint64(nil, uint64(len(value)))
+	return name + "\n" + string(size) + value + "\n"
+}
+
+func TestJournalExportReader(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []map[string]string
+	}{
+		{
+			"text fields",
+			"__REALTIME_TIMESTAMP=1700000000000000\n_SYSTEMD_UNIT=sshd.service\nMESSAGE=a=b\n\n" +
+				"_SYSTEMD_UNIT=cron.service\n\n",
+			[]map[string]string{
+				{"__REALTIME_TIMESTAMP": "1700000000000000", "_SYSTEMD_UNIT": "sshd.service", "MESSAGE": "a=b"},
+				{"_SYSTEMD_UNIT": "cron.service"},
+			},
+		},
+		{
+			"binary field",
+			"_SYSTEMD_UNIT=sshd.service\n" + journalBinaryField("MESSAGE", "line 1\nline 2\x00") + "PRIORITY=6\n\n",
+			[]map[string]string{
+				{"_SYSTEMD_UNIT": "sshd.service", "MESSAGE": "line 1\nline 2\x00", "PRIORITY": "6"},
+			},
+		},
+		{
+			"empty binary field",
+			journalBinaryField("MESSAGE", "") + "\n",
+			[]map[string]string{{"MESSAGE": ""}},
+		},
+		{
+			"missing trailing newline",
+			"_SYSTEMD_UNIT=sshd.service\n\n_SYSTEMD_UNIT=cron.service",
+			[]map[string]string{
+				{"_SYSTEMD_UNIT": "sshd.service"},
+				{"_SYSTEMD_UNIT": "cron.service"},
+			},
+		},
+		{
+			"missing trailing empty line",
+			"_SYSTEMD_UNIT=sshd.service\n",
+			[]map[string]string{{"_SYSTEMD_UNIT": "sshd.service"}},
+		},
+		{
+			"extra empty lines",
+			"\n\n_SYSTEMD_UNIT=sshd.service\n\n\n\n",
+			[]map[string]string{{"_SYSTEMD_UNIT": "sshd.service"}},
+		},
+		{"empty", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newJournalExportReader(bufio.NewReader(strings.NewReader(tt.input)))
+			var got []map[string]string
+			for {
+				entry, err := r.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, entry)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJournalExportReaderInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  error
+	}{
+		{"truncated binary size", "MESSAGE\n\x05\x00", io.ErrUnexpectedEOF},
+		{"truncated binary value", "MESSAGE\n\x05\x00\x00\x00\x00\x00\x00\x00abc", io.ErrUnexpectedEOF},
+		{"binary value without newline", "MESSAGE\n\x03\x00\x00\x00\x00\x00\x00\x00abcd", errJournalInvalid},
+		{"huge binary size", "MESSAGE\n\xff\xff\xff\xff\xff\xff\xff\xff", errJournalInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newJournalExportReader(bufio.NewReader(strings.NewReader(tt.input)))
+			if _, err := r.Next(); err != tt.want {
+				t.Errorf("got error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -8,7 +8,9 @@ import (
 	"io"
 	"log"
 	"math"
+	"net"
 	"os"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -28,13 +30,36 @@ import (
 
 type Config struct {
 	// sketch
-	K            int
-	Width        int
-	Depth        int
-	Decay        float64
-	DecayLUTSize int
-	TickSize     time.Duration
-	WindowSize   time.Duration
+	K              int
+	Width          int
+	Depth          int
+	Decay          float64
+	DecayLUTSize   int
+	TickSize       time.Duration
+	WindowSize     time.Duration
+	HistoryLength  int
+	Windows        []time.Duration
+	ChangeWindow   time.Duration
+	Hierarchy      string
+	HierarchyDepth int
+	Mode           string
+	HalfLife       time.Duration
+	ShadowExact    bool
+	ExactBelow     int
+	NewWindows     int
+	NewCapacity    int
+	ErrorBounds    bool
+	Confidence     float64
+	Memory         int
+	Shards         int
+	QueueSize      int
+	QueueOverflow  string
+	QueueSample    int
+
+	// anomaly detection
+	AnomalyThreshold float64
+	AnomalyAlpha     float64
+	AnomalyBell      bool
 
 	// render
 	PlotFPS       int
@@ -42,29 +67,83 @@ type Config struct {
 	ItemCountsFPS int
 	TrackSelected bool
 	LogScale      bool
+	RateUnit      string
 	ViewSplit     int
 
+	// state
+	StateFile     string
+	StateInterval time.Duration
+	StatePush     string
+
+	// archive
+	ArchiveInterval time.Duration
+	ArchiveLength   int
+	ArchiveFile     string
+
+	MergeFiles    string
+	MergeListen   string
+	MergeInterval time.Duration
+
 	// input
 	JSON            bool
+	Format          string
+	Input           string
 	TimestampLayout string
+	PcapKey         string
+	PcapCount       string
+	JournalField    string
+	Distinct        string
+	Value           string
+
+	PrometheusURL      string
+	PrometheusMetric   string
+	PrometheusLabel    string
+	PrometheusInterval time.Duration
+
+	OTLPListen    string
+	OTLPAttribute string
+	OTLPTime      string
 }
 
 var config = Config{
-	K:            50,
-	Width:        3000,
-	Depth:        3,
-	Decay:        0.9,
-	DecayLUTSize: 8192,
-	TickSize:     time.Second,
-	WindowSize:   10 * time.Second,
+	K:              50,
+	Width:          3000,
+	Depth:          3,
+	Decay:          0.9,
+	Confidence:     0.95,
+	NewCapacity:    100000,
+	DecayLUTSize:   8192,
+	TickSize:       time.Second,
+	AnomalyAlpha:   0.1,
+	HierarchyDepth: 3,
+	Mode:           modeSliding,
+	Shards:         1,
+	QueueSize:      1 << 16,
+	QueueOverflow:  overflowBlock,
+	QueueSample:    10,
+	WindowSize:     10 * time.Second,
 
 	ViewSplit:     50,
 	PlotFPS:       20,
 	ItemsFPS:      1,
 	ItemCountsFPS: 5,
 
+	StateInterval: time.Minute,
+	ArchiveLength: 1440,
+	MergeInterval: 10 * time.Second,
+
 	JSON:            false,
+	Format:          formatText,
 	TimestampLayout: time.RFC3339,
+	PcapKey:         pcapKeySrc,
+	PcapCount:       pcapCountPackets,
+	JournalField:    "_SYSTEMD_UNIT",
+
+	PrometheusInterval: 15 * time.Second,
+
+	OTLPListen:    ":4318",
+	OTLPAttribute: "service.name",
+	OTLPTime:      otlpTimeEvent,
 }
 
 var (
@@ -84,34 +163,351 @@ func main() {
 	flag.IntVar(&config.Width, "width", config.Width, "Sketch width")
 	flag.IntVar(&config.Depth, "depth", config.Depth, "Sketch depth")
 	flag.DurationVar(&config.WindowSize, "window", config.WindowSize, "Window size")
+	flag.Func("windows", "Comma-separated window sizes to track at once, e.g. 1m,15m,1h (default: -window)", func(value string) error {
+		config.Windows = config.Windows[:0]
+		for _, w := range strings.Split(value, ",") {
+			d, err := time.ParseDuration(strings.TrimSpace(w))
+			if err != nil {
+				return err
+			}
+			config.Windows = append(config.Windows, d)
+		}
+		return nil
+	})
+	flag.DurationVar(&config.ChangeWindow, "change-window", config.ChangeWindow, "Length of the recent and previous sub-windows compared in the risers/fallers views (default: half the window)")
+	flag.StringVar(&config.Hierarchy, "hierarchy", config.Hierarchy, "Count items also at their prefix levels, and show them as a tree (ip, path)")
+	flag.IntVar(&config.HierarchyDepth, "hierarchy-depth", config.HierarchyDepth, "Number of path segment levels above the paths themselves for -hierarchy=path")
 	flag.DurationVar(&config.TickSize, "tick", config.TickSize, "Sliding window tick size (time bucket precision)")
+	flag.StringVar(&config.Mode, "mode", config.Mode, "Counting mode (sliding: counts over a sliding window, decayed: exponentially decayed counts)")
+	flag.DurationVar(&config.HalfLife, "half-life", config.HalfLife, "Half-life of the counts with -mode=decayed (default: the window times ln 2, for a mean lifetime of one window)")
+	flag.IntVar(&config.HistoryLength, "history-length", config.HistoryLength, "Number of aged counters per sketch bucket (default: one per tick in the window)")
+	flag.Func("memory", "Memory budget for the sketches, e.g. 64MiB (alternative to -width)", func(value string) error {
+		n, err := parseByteSize(value)
+		config.Memory = n
+		return err
+	})
+	flag.IntVar(&config.Shards, "shards", config.Shards, "Number of hash-partitioned sketch shards, each with 1/N of the width, and of text parsing workers")
+	flag.IntVar(&config.QueueSize, "queue-size", config.QueueSize, "Capacity (in records) of each shard's ingestion queue")
+	flag.StringVar(&config.QueueOverflow, "queue-overflow", config.QueueOverflow, "What to do with records when an ingestion queue is full (block, drop-newest, sample)")
+	flag.IntVar(&config.QueueSample, "queue-sample", config.QueueSample, "With -queue-overflow=sample, keep every n-th record (counting it n times, and waiting for room to queue it) while a queue is full")
+	flag.BoolVar(&config.ShadowExact, "shadow-exact", config.ShadowExact, "Count exactly beside the sketch, and show the sketch's errors")
+	flag.BoolVar(&config.ErrorBounds, "error-bounds", config.ErrorBounds, "Show the counts as ranges up to their error bounds (the sketch does not overestimate), and mark items whose ranks are statistically indistinguishable")
+	flag.Float64Var(&config.Confidence, "confidence", config.Confidence, "Confidence level of the -error-bounds (0,1)")
+	flag.IntVar(&config.NewWindows, "new-windows", config.NewWindows, "Flag items not seen in this many (longest) windows before as NEW (0: disabled)")
+	flag.IntVar(&config.NewCapacity, "new-capacity", config.NewCapacity, "Expected number of distinct items per window, for sizing the -new-windows filters")
+	flag.IntVar(&config.ExactBelow, "exact-below", config.ExactBelow, "Count exactly instead of using the sketch while a window has fewer than this many distinct items (0: disabled)")
+	flag.Float64Var(&config.AnomalyThreshold, "anomaly-threshold", config.AnomalyThreshold, "Flag items whose latest tick deviates by at least this z-score from their recent per-tick counts (0: disabled)")
+	flag.Float64Var(&config.AnomalyAlpha, "anomaly-alpha", config.AnomalyAlpha, "Smoothing factor of the moving average and variance used for anomaly detection (0,1]")
+	flag.BoolVar(&config.AnomalyBell, "anomaly-bell", config.AnomalyBell, "Ring the terminal bell when an item becomes anomalous")
 	flag.Float64Var(&config.Decay, "decay", config.Decay, "Counter decay probability on collisions")
 	flag.IntVar(&config.DecayLUTSize, "decay-lut-size", config.DecayLUTSize, "Sketch decay look-up table size")
 	flag.IntVar(&config.PlotFPS, "plot-fps", config.PlotFPS, "Plot refresh rate (frames per second)")
 	flag.IntVar(&config.ItemsFPS, "items-fps", config.ItemsFPS, "Item refresh rate (frames per second)")
 	flag.IntVar(&config.ItemCountsFPS, "item-counts-fps", config.ItemCountsFPS, "Item counts refresh rate (frames per second)")
-	flag.BoolVar(&config.JSON, "json", config.JSON, "Read JSON records {item,[count],[timestamp]} instead of text lines")
+	flag.BoolVar(&config.JSON, "json", config.JSON, "Read JSON records {item,[count],[timestamp]} instead of text lines (same as -format=json)")
+	flag.StringVar(&config.Format, "format", config.Format, "Input format (text, json, msgpack, pcap, journal-export, prometheus, otlp, merge)")
+	flag.StringVar(&config.Input, "input", config.Input, "Read input from this file instead of stdin")
+	flag.StringVar(&config.PcapKey, "pcap-key", config.PcapKey, "Item key for pcap input (src, dst, flow, dport)")
+	flag.StringVar(&config.PcapCount, "pcap-count", config.PcapCount, "What to count for pcap input (packets, bytes)")
+	flag.StringVar(&config.JournalField, "journal-field", config.JournalField, "Journal field to use as the item key for journal-export input")
+	flag.StringVar(&config.Distinct, "distinct", config.Distinct, "Record field (pcap key, journal field, or otlp attribute) whose distinct values to estimate per item")
+	flag.StringVar(&config.Value, "value", config.Value, "Numeric record field (journal field, or otlp attribute) whose quantiles (p50, p95, p99) to estimate per item")
+	flag.StringVar(&config.PrometheusURL, "prometheus-url", config.PrometheusURL, "URL (or file path) of the Prometheus exposition to poll for prometheus input (default: -input)")
+	flag.StringVar(&config.PrometheusMetric, "prometheus-metric", config.PrometheusMetric, "Counter metric to count for prometheus input (default: all counters)")
+	flag.StringVar(&config.PrometheusLabel, "prometheus-label", config.PrometheusLabel, "Label to use as the item key for prometheus input (default: the full series name)")
+	flag.DurationVar(&config.PrometheusInterval, "prometheus-interval", config.PrometheusInterval, "Poll interval for prometheus input")
+	flag.StringVar(&config.OTLPListen, "otlp-listen", config.OTLPListen, "Listen address of the OTLP/HTTP endpoint for otlp input")
+	flag.StringVar(&config.OTLPAttribute, "otlp-attribute", config.OTLPAttribute, "Log (or resource) attribute to use as the item key for otlp input")
+	flag.StringVar(&config.OTLPTime, "otlp-time", config.OTLPTime, "Log record timestamp to use for otlp input (event, observed)")
 	flag.BoolVar(&config.TrackSelected, "track-selected", config.TrackSelected, "Keep the selected item focused")
 	flag.BoolVar(&config.LogScale, "log-scale", config.LogScale, "Use a logarithmic Y axis scale (default: linear)")
+	flag.StringVar(&config.RateUnit, "rate", config.RateUnit, "Show counts as average rates per unit (s, min) instead of window sums")
 	flag.StringVar(&config.TimestampLayout, "json-timestamp-layout", config.TimestampLayout, "Layout for string values of the timestamp field")
 	flag.IntVar(&config.ViewSplit, "view-split", config.ViewSplit, "Split the view at this % of the total screen width [20,80]")
+	flag.StringVar(&config.StateFile, "state-file", config.StateFile, "Restore the sketch state from this file on start, and save it there periodically and on exit")
+	flag.DurationVar(&config.StateInterval, "state-interval", config.StateInterval, "Interval for saving the sketch state to the -state-file (0: only on exit)")
+	flag.StringVar(&config.StatePush, "state-push", config.StatePush, "Send the sketch state to this merging instance (host:port) every -state-interval")
+	flag.DurationVar(&config.ArchiveInterval, "archive-interval", config.ArchiveInterval, "Archive the top-K items of each window at this interval, for scrolling back beyond the window (0: disabled)")
+	flag.IntVar(&config.ArchiveLength, "archive-length", config.ArchiveLength, "Number of archived snapshots to keep")
+	flag.StringVar(&config.ArchiveFile, "archive-file", config.ArchiveFile, "Restore the archive from this file on start, and append each snapshot to it")
+	flag.StringVar(&config.MergeFiles, "merge-files", config.MergeFiles, "Comma-separated state files (or glob patterns) to merge for merge input")
+	flag.StringVar(&config.MergeListen, "merge-listen", config.MergeListen, "Listen address for receiving pushed sketch states for merge input")
+	flag.DurationVar(&config.MergeInterval, "merge-interval", config.MergeInterval, "Interval for re-reading and merging sketch states for merge input")
 	flag.Parse()
 
 	config.ViewSplit = max(20, config.ViewSplit)
 	config.ViewSplit = min(80, config.ViewSplit)
+	if !slices.Contains(rateUnits, config.RateUnit) {
+		log.Fatalf("unknown rate unit %q", config.RateUnit)
+	}
+	if config.Confidence <= 0 || config.Confidence >= 1 {
+		log.Fatal("-confidence must be in (0,1)")
+	}
+	if config.NewWindows < 0 {
+		log.Fatal("-new-windows must not be negative")
+	}
+	if config.NewWindows > 0 && config.NewCapacity < 1 {
+		log.Fatal("-new-capacity must be positive")
+	}
+	if config.ArchiveInterval > 0 {
+		if config.ArchiveInterval < config.TickSize {
+			log.Fatal("-archive-interval must be at least -tick")
+		}
+		if config.ArchiveLength < 1 {
+			log.Fatal("-archive-length must be positive")
+		}
+	} else if config.ArchiveFile != "" {
+		log.Fatal("-archive-file requires -archive-interval")
+	}
+
+	switch config.Mode {
+	case modeSliding:
+	case modeDecayed:
+		for _, f := range []struct {
+			name string
+			set  bool
+		}{
+			{"-windows", len(config.Windows) > 0},
+			{"-history-length", config.HistoryLength != 0},
+			{"-change-window", config.ChangeWindow != 0},
+			{"-anomaly-threshold", config.AnomalyThreshold != 0},
+			{"-shadow-exact", config.ShadowExact},
+			{"-exact-below", config.ExactBelow != 0},
+			{"-state-file", config.StateFile != ""},
+			{"-state-push", config.StatePush != ""},
+			{"-format=merge", config.Format == formatMerge},
+		} {
+			if f.set {
+				log.Fatalf("%s is not supported with -mode=decayed", f.name)
+			}
+		}
+		if config.HalfLife == 0 {
+			config.HalfLife = time.Duration(float64(config.WindowSize) * math.Ln2)
+		}
+		if config.HalfLife < config.TickSize {
+			log.Fatalf("half-life %v is smaller than the tick size %v", config.HalfLife, config.TickSize)
+		}
+		config.Windows = []time.Duration{meanLifetime()}
+	default:
+		log.Fatalf("unknown mode %q", config.Mode)
+	}
+	if len(config.Windows) == 0 {
+		config.Windows = []time.Duration{config.WindowSize}
+	}
+	for _, w := range config.Windows {
+		if w < config.TickSize {
+			log.Fatalf("window size %v is smaller than the tick size %v", w, config.TickSize)
+		}
+	}
+	if config.ChangeWindow != 0 && config.ChangeWindow < config.TickSize {
+		log.Fatalf("change window %v is smaller than the tick size %v", config.ChangeWindow, config.TickSize)
+	}
+	switch config.Hierarchy {
+	case "", hierarchyIP, hierarchyPath:
+	default:
+		log.Fatalf("unknown hierarchy %q", config.Hierarchy)
+	}
+	if config.HierarchyDepth < 1 {
+		log.Fatal("-hierarchy-depth must be positive")
+	}
+	if config.AnomalyAlpha <= 0 || config.AnomalyAlpha > 1 {
+		log.Fatal("-anomaly-alpha must be in (0,1]")
+	}
+	if config.HistoryLength < 0 {
+		log.Fatal("-history-length must not be negative")
+	}
+	if config.Shards < 1 {
+		log.Fatal("-shards must be positive")
+	}
+	if config.QueueSize < 1 {
+		log.Fatal("-queue-size must be positive")
+	}
+	switch config.QueueOverflow {
+	case overflowBlock, overflowDropNewest:
+	case overflowSample:
+		if config.QueueSample < 1 {
+			log.Fatal("-queue-sample must be positive")
+		}
+	default:
+		log.Fatalf("unknown queue overflow policy %q", config.QueueOverflow)
+	}
+	if config.Memory > 0 {
+		explicit := make(map[string]bool)
+		flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+		if explicit["width"] {
+			log.Fatal("-memory and -width are mutually exclusive")
+		}
+		if !explicit["depth"] {
+			config.Depth = max(3, int(math.Log(float64(config.K))))
+		}
+		width, err := widthForMemory(config.Memory, config.Windows)
+		if err != nil {
+			log.Fatal(err)
+		}
+		config.Width = width
+	}
+
+	if config.JSON {
+		config.Format = formatJSON
+	}
+	switch config.Format {
+	case formatText, formatJSON, formatMsgpack, formatPcap, formatJournalExport, formatPrometheus, formatOTLP, formatMerge:
+	default:
+		log.Fatalf("unknown input format %q", config.Format)
+	}
+	if config.Format == formatPrometheus {
+		if config.PrometheusURL == "" {
+			config.PrometheusURL = config.Input
+		}
+		if config.PrometheusURL == "" || config.PrometheusURL == "-" {
+			log.Fatal("prometheus input requires -prometheus-url or an -input file")
+		}
+		if config.PrometheusInterval <= 0 {
+			log.Fatal("-prometheus-interval must be positive")
+		}
+	}
+	if config.Format == formatMerge {
+		if config.MergeFiles == "" && config.MergeListen == "" {
+			log.Fatal("merge input requires -merge-files or -merge-listen")
+		}
+		if config.MergeInterval <= 0 {
+			log.Fatal("-merge-interval must be positive")
+		}
+		if config.ShadowExact {
+			log.Fatal("-shadow-exact is not supported for merge input")
+		}
+		if config.ExactBelow != 0 {
+			log.Fatal("-exact-below is not supported for merge input")
+		}
+		if config.NewWindows != 0 {
+			log.Fatal("-new-windows is not supported for merge input")
+		}
+	}
+	switch config.OTLPTime {
+	case otlpTimeEvent, otlpTimeObserved:
+	default:
+		log.Fatalf("unknown otlp time %q", config.OTLPTime)
+	}
+	switch config.PcapKey {
+	case pcapKeySrc, pcapKeyDst, pcapKeyFlow, pcapKeyDstPort:
+	default:
+		log.Fatalf("unknown pcap key %q", config.PcapKey)
+	}
+	switch config.PcapCount {
+	case pcapCountPackets, pcapCountBytes:
+	default:
+		log.Fatalf("unknown pcap count %q", config.PcapCount)
+	}
+	if config.Distinct != "" {
+		switch config.Format {
+		case formatText, formatPrometheus, formatMerge:
+			log.Fatalf("-distinct is not supported for %s input", config.Format)
+		case formatPcap:
+			switch config.Distinct {
+			case pcapKeySrc, pcapKeyDst, pcapKeyFlow, pcapKeyDstPort:
+			default:
+				log.Fatalf("unknown pcap key %q for -distinct", config.Distinct)
+			}
+		}
+	}
+	if config.Value != "" {
+		switch config.Format {
+		case formatText, formatPcap, formatPrometheus, formatMerge:
+			log.Fatalf("-value is not supported for %s input", config.Format)
+		}
+	}
+
+	input := io.Reader(os.Stdin)
+	switch {
+	case config.Format == formatPrometheus, config.Format == formatOTLP, config.Format == formatMerge:
+		// not read from -input
+	case config.Input != "" && config.Input != "-":
+		f, err := os.Open(config.Input)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		input = f
+	}
+	var listener net.Listener
+	listen := ""
+	switch config.Format {
+	case formatOTLP:
+		listen = config.OTLPListen
+	case formatMerge:
+		listen = config.MergeListen
+	}
+	if listen != "" {
+		l, err := net.Listen("tcp", listen)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer l.Close()
+		listener = l
+	}
+
+	m := newModel(newShards(config.Shards), input)
+	m.listener = listener
+	if config.StatePush != "" {
+		m.pusher = &statePusher{addr: config.StatePush}
+	}
+	if config.StateFile != "" {
+		st, err := loadState(config.StateFile, m.shards)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if st != nil {
+			m.restoreState(st)
+		}
+	}
+	if config.ArchiveInterval > 0 {
+		a, err := openArchive(config.ArchiveFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		m.archive = a
+	}
+	_, err := tui.NewProgram(m, tui.WithInputTTY()).Run()
+	if config.StateFile != "" {
+		if err := m.saveState(config.StateFile); err != nil {
+			log.Print(err)
+		}
+	}
+	if m.archive != nil {
+		if err := m.archive.Close(); err != nil {
+			log.Print(err)
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
 
-	sketch := sliding.New(config.K,
-		int(config.WindowSize/config.TickSize),
-		sliding.WithWidth(config.Width),
+// newSketch returns an empty sketch of one shard for the window.
+func newSketch(window time.Duration) *sliding.Sketch {
+	return sliding.New(sketchK(), int(window/config.TickSize), sketchOptions(shardWidth())...)
+}
+
+// windowTicks returns the window sizes in ticks.
+func windowTicks() []int {
+	ticks := make([]int, len(config.Windows))
+	for i, window := range config.Windows {
+		ticks[i] = int(window / config.TickSize)
+	}
+	return ticks
+}
+
+func sketchOptions(width int) []sliding.Option {
+	opts := []sliding.Option{
+		sliding.WithWidth(width),
 		sliding.WithDepth(config.Depth),
 		sliding.WithDecay(float32(config.Decay)),
 		sliding.WithDecayLUTSize(config.DecayLUTSize),
-	)
-
-	m := newModel(sketch)
-	if _, err := tui.NewProgram(m, tui.WithInputTTY()).Run(); err != nil {
-		log.Fatal(err)
 	}
+	if config.HistoryLength > 0 {
+		opts = append(opts, sliding.WithBucketHistoryLength(config.HistoryLength))
+	}
+	return opts
 }
 
 type model struct {
@@ -119,6 +515,7 @@ type model struct {
 
 	track    bool
 	logScale atomic.Bool
+	rateUnit atomic.Int32 // index into rateUnits
 
 	list         list.Model
 	listStyle    styles.Style
@@ -126,19 +523,66 @@ type model struct {
 	help         help.Model
 	plot         *plot.Canvas
 
-	sketch         *sliding.Sketch
-	sketchMu       sync.Mutex
+	shards         []*shard
+	window         int // index of the active window
+	view           int // leaderboard view, see viewTop
 	plotData       [][]float64
+	plotMax        float64 // largest plotted value, before the log scale is applied
 	plotLineColors []plot.Color
 	listItems      []heap.Item
+	listCounts     [][]uint32   // per-window counts of the list items, if there are several windows
+	listExact      []uint32     // exact counts of the list items, with -shadow-exact
+	listErrors     []float64    // error bounds of the list items' counts, with -error-bounds
+	listTies       []bool       // whether the list items' ranks are indistinguishable from a neighbour's, with -error-bounds
+	listNew        []bool       // whether the list items are new, with -new-windows
+	listFirstSeen  []int        // ticks since the new list items were first seen, with -new-windows
+	listDistinct   []float64    // distinct counts of the list items, with -distinct
+	listQuantiles  [][]float64  // value quantiles of the list items, with -value
+	listChanges    []itemChange // changes of the list items, in the risers and fallers views
+	listAnomalies  []float64    // anomaly scores of the list items, with -anomaly-threshold
+	anomalous      map[string]bool
+	bellItems      map[string]bool // anomalous items of the live view, kept while scrolling the archive
+	bell           bool            // an item became anomalous, see bellCmd
+	listTree       []treeNode      // tree positions of the list items, with -hierarchy
+	expanded       map[string]bool // expanded tree nodes, with -hierarchy
+	exactShards    int             // number of shards counting exactly, with -exact-below
+	newFresh       int             // number of new items in the last window, with -new-windows
+	newUntracked   int             // number of new items in the last window that are not tracked
+	newLearning    int             // ticks until new items are detected
+	exactItems     int             // number of distinct items in the active window of the shards counting exactly
+	precision      float64
+	recall         float64
+	sizeBytes      int // memory footprint of the sketches
 	latestTick     time.Time
-
+	resumeTick     time.Time    // latest tick of the restored state until the first tick after it, see takeResumeTick
+	checkpointAt   time.Time    // time of the latest checkpoint, see -state-interval
+	checkpointErr  error        // error of the latest checkpoint, if it failed
+	pusher         *statePusher // connection to the merging instance, with -state-push
+	mergeSources   int          // number of merged states, for merge input
+	mergeRejects   []string     // incompatible or unreadable states, with the reasons, for merge input
+
+	archive    *archive  // with -archive-interval
+	archivedAt time.Time // time of the latest archived snapshot
+	scrollTime time.Time // time of the archived snapshot shown instead of the live counts, if any
+
+	totals      *streamTotals
+	windowTotal streamTick // stream totals of the active window
+	windowRate  streamRate // stream rate of the active window
+
+	ingested           atomic.Uint64 // number of records counted
+	throughput         float64       // records per second
+	throughputAt       time.Time
+	throughputIngested uint64
+
+	input              io.Reader
+	listener           net.Listener
 	timestampsFromData atomic.Bool
+	scrapeErr          error // error of the latest scrape, for prometheus input
 
 	mu sync.Mutex
 }
 
-func newModel(sketch *sliding.Sketch) *model {
+func newModel(shards []*shard, input io.Reader) *model {
 	const (
 		defaultWidth  = 80
 		defaultHeight = 20
@@ -163,8 +607,9 @@ func newModel(sketch *sliding.Sketch) *model {
 	l.SetShowTitle(false)
 	l.SetShowStatusBar(false)
 
+	n := shards[0].plotLength(0)
 	p := plot.NewCanvas(defaultWidth, defaultHeight)
-	p.NumDataPoints = sketch.BucketHistoryLength
+	p.NumDataPoints = n
 	p.ShowAxis = false
 	p.LineColors = make([]plot.Color, config.K+1)
 
@@ -172,18 +617,28 @@ func newModel(sketch *sliding.Sketch) *model {
 
 	m := &model{
 		track:          config.TrackSelected,
-		sketch:         sketch,
+		shards:         shards,
+		input:          input,
 		help:           help,
 		list:           l,
 		listDelegate:   &d,
 		plot:           &p,
 		plotData:       make([][]float64, config.K+1),
 		plotLineColors: make([]plot.Color, config.K+1),
+		expanded:       make(map[string]bool),
+		totals:         newStreamTotals(windowTicks()),
 	}
 	m.timestampsFromData.Store(true)
 	m.logScale.Store(config.LogScale)
+	m.rateUnit.Store(int32(slices.Index(rateUnits, config.RateUnit)))
+	keys.Window.SetEnabled(len(config.Windows) > 1)
+	keys.Changes.SetEnabled(config.Mode == modeSliding || config.NewWindows > 0)
+	keys.Changes.SetHelp("c", viewHelp())
+	keys.Expand.SetEnabled(config.Hierarchy != "")
+	keys.Collapse.SetEnabled(config.Hierarchy != "")
+	keys.Archive.SetEnabled(config.ArchiveInterval > 0)
 	for i := range m.plotData {
-		m.plotData[i] = make([]float64, m.sketch.BucketHistoryLength)
+		m.plotData[i] = make([]float64, n)
 	}
 	m.plot.Fill(m.plotData)
 	return m
@@ -196,13 +651,36 @@ func (m *model) rightWidth() int {
 	return m.width * (100 - config.ViewSplit) / 100
 }
 func (m *model) readAndCountInput() tui.Cmd {
-	if term.IsTerminal(os.Stdin.Fd()) {
+	switch config.Format {
+	case formatPrometheus:
+		return func() tui.Msg {
+			m.pollPrometheusItems()
+			return nil
+		}
+	case formatOTLP:
+		return func() tui.Msg {
+			m.serveOTLPLogs()
+			return nil
+		}
+	case formatMerge:
+		return func() tui.Msg {
+			m.mergeSnapshots()
+			return nil
+		}
+	}
+	if m.input == os.Stdin && term.IsTerminal(os.Stdin.Fd()) {
 		return nil // no data on stdin
 	}
 	return func() tui.Msg {
-		switch {
-		case config.JSON:
+		switch config.Format {
+		case formatJSON:
 			m.readJSONItems()
+		case formatMsgpack:
+			m.readMsgpackItems()
+		case formatPcap:
+			m.readPcapItems()
+		case formatJournalExport:
+			m.readJournalExportItems()
 		default:
 			m.readTextItems()
 		}
@@ -210,22 +688,17 @@ func (m *model) readAndCountInput() tui.Cmd {
 	}
 }
 
-func (m *model) readTextItems() {
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		m.sketchMu.Lock()
-		m.sketch.Incr(scanner.Text())
-		m.sketchMu.Unlock()
-	}
-}
-
 func (m *model) readJSONItems() {
+	if config.Distinct != "" || config.Value != "" {
+		m.readJSONFields()
+		return
+	}
 	var item struct {
 		Item      string `json:"item"`
 		Count     int    `json:"count"`
 		Timestamp any    `json:"timestamp"`
 	}
-	dec := json.NewDecoder(bufio.NewReader(os.Stdin))
+	dec := json.NewDecoder(bufio.NewReader(m.input))
 	var last time.Time
 	for {
 		err := dec.Decode(&item)
@@ -235,30 +708,92 @@ func (m *model) readJSONItems() {
 			}
 			return
 		}
-		if item.Timestamp == nil {
-			m.timestampsFromData.Store(false)
-		}
-		if m.timestampsFromData.Load() {
-			var t time.Time
-			switch timestamp := item.Timestamp.(type) {
-			case int:
-				t = time.Unix(int64(timestamp), 0)
-			case float64:
-				t = time.Unix(int64(timestamp), 0)
-			case string:
-				t, _ = time.Parse(config.TimestampLayout, timestamp)
-			}
-			if !t.IsZero() {
-				last = m.doSketchTicks(t, last)
-				m.mu.Lock()
-				m.latestTick = last
-				m.mu.Unlock()
-			}
+		last = m.countRecord(record{
+			Item:      item.Item,
+			Count:     item.Count,
+			Timestamp: item.Timestamp,
+		}, last)
+	}
+}
+
+// readJSONFields reads JSON records like readJSONItems, also picking up their -distinct and -value fields.
+func (m *model) readJSONFields() {
+	dec := json.NewDecoder(bufio.NewReader(m.input))
+	var last time.Time
+	for {
+		var fields map[string]any
+		if err := dec.Decode(&fields); err != nil {
+			return
 		}
-		m.sketchMu.Lock()
-		m.sketch.Add(item.Item, max(1, uint32(item.Count)))
-		m.sketchMu.Unlock()
+		value, hasValue := asFloat(fields[config.Value])
+		last = m.countRecord(record{
+			Item:      asString(fields["item"]),
+			Count:     asInt(fields["count"]),
+			Timestamp: fields["timestamp"],
+			Distinct:  asString(fields[config.Distinct]),
+			Value:     value,
+			HasValue:  hasValue,
+		}, last)
+	}
+}
+
+const (
+	formatText          = "text"
+	formatJSON          = "json"
+	formatMsgpack       = "msgpack"
+	formatPcap          = "pcap"
+	formatJournalExport = "journal-export"
+	formatPrometheus    = "prometheus"
+	formatOTLP          = "otlp"
+	formatMerge         = "merge"
+)
+
+// record is a single structured input record, as read in the JSON-like input formats.
+type record struct {
+	Item      string
+	Count     int
+	Timestamp any
+	Distinct  string  // value of the -distinct field
+	Value     float64 // value of the -value field, if HasValue
+	HasValue  bool
+}
+
+// countRecord adds the record to the sketch, ticking the sketch forward to the record's timestamp first.
+// It returns the latest tick time.
+func (m *model) countRecord(r record, last time.Time) time.Time {
+	if r.Timestamp == nil && m.timestampsFromData.Swap(false) {
+		m.catchUp() // from now on, the wall clock ticks the sketch
+	}
+	if m.timestampsFromData.Load() {
+		if t := parseTimestamp(r.Timestamp); !t.IsZero() {
+			last = m.doSketchTicks(t, last)
+			m.mu.Lock()
+			m.latestTick = last
+			m.mu.Unlock()
+		}
+	}
+	m.add(r)
+	return last
+}
+
+// parseTimestamp interprets numbers as unix (second precision) timestamps, and strings using config.TimestampLayout.
+func parseTimestamp(timestamp any) time.Time {
+	var t time.Time
+	switch timestamp := timestamp.(type) {
+	case int:
+		t = time.Unix(int64(timestamp), 0)
+	case int64:
+		t = time.Unix(timestamp, 0)
+	case uint64:
+		t = time.Unix(int64(timestamp), 0)
+	case float64:
+		t = time.Unix(int64(timestamp), 0)
+	case string:
+		t, _ = time.Parse(config.TimestampLayout, timestamp)
+	case time.Time:
+		t = timestamp
 	}
+	return t
 }
 
 func (m *model) sketchTickCmd() tui.Cmd {
@@ -268,7 +803,7 @@ func (m *model) sketchTickCmd() tui.Cmd {
 		for {
 			select {
 			case t := <-ticker.C:
-				if config.JSON && m.timestampsFromData.Load() {
+				if config.Format != formatText && m.timestampsFromData.Load() {
 					continue
 				}
 				t = t.Truncate(config.TickSize)
@@ -283,14 +818,16 @@ func (m *model) sketchTickCmd() tui.Cmd {
 
 func (m *model) doSketchTicks(t time.Time, last time.Time) time.Time {
 	t = t.Truncate(config.TickSize)
+	if last.IsZero() {
+		last = m.takeResumeTick() // catch up with the ticks that elapsed since the state was saved
+	}
 	if last.IsZero() {
 		last = t
 		return last
 	}
 	if ticks := int(t.Sub(last) / config.TickSize); ticks > 0 {
-		m.sketchMu.Lock()
-		m.sketch.Ticks(ticks)
-		m.sketchMu.Unlock()
+		m.archiveTick(t)
+		m.queueTicks(ticks)
 		last = t
 	}
 	return last
@@ -324,7 +861,7 @@ func doPlotTick() tui.Cmd {
 }
 
 func (m *model) Init() tui.Cmd {
-	return tui.Batch(m.sketchTickCmd(), m.readAndCountInput(), doPlotTick(), doItemsTick(), doItemCountsTick())
+	return tui.Batch(m.applyQueuedCmd(), m.sketchTickCmd(), m.readAndCountInput(), doPlotTick(), doItemsTick(), doItemCountsTick(), doCheckpointTick())
 }
 
 func (m *model) Update(msg tui.Msg) (tui.Model, tui.Cmd) {
@@ -337,24 +874,50 @@ func (m *model) Update(msg tui.Msg) (tui.Model, tui.Cmd) {
 	case ItemsTickMsg:
 		m.updateTopK()
 		cmdList := m.updateList(msg)
-		return m, tui.Batch(cmdList, doItemsTick())
+		return m, tui.Batch(cmdList, m.bellCmd(), doItemsTick())
 	case PlotTickMsg:
 		cmdPlot := m.updatePlot(msg)
 		return m, tui.Batch(cmdPlot, doPlotTick())
+	case CheckpointTickMsg:
+		return m, tui.Batch(m.checkpointCmd(), doCheckpointTick())
 	case tui.KeyMsg:
 		switch {
 		case key.Matches(msg, keys.Scale):
 			m.logScale.Store(!m.logScale.Load())
 			return m, nil
+		case key.Matches(msg, keys.Rate):
+			m.nextRateUnit()
+			return m, m.updateList(nil)
 		case key.Matches(msg, keys.Track):
 			m.toggleTracking()
 			return m, nil
+		case key.Matches(msg, keys.Window):
+			m.nextWindow()
+			return m, m.updateList(nil)
+		case key.Matches(msg, keys.Changes):
+			m.nextView()
+			return m, m.updateList(nil)
+		case key.Matches(msg, keys.Expand):
+			m.expandSelected()
+			return m, m.updateList(nil)
+		case key.Matches(msg, keys.Collapse):
+			parent := m.collapseSelected()
+			cmd := m.updateList(nil)
+			if parent != "" {
+				m.selectItem(parent)
+			}
+			return m, cmd
+		case key.Matches(msg, keys.Archive):
+			m.scrollArchive(msg.String() == "[")
+			m.updateTopK()
+			return m, m.updateList(nil)
 		case key.Matches(msg, keys.Quit):
 			return m, tui.Quit
 		}
 	case tui.WindowSizeMsg:
 		w, h := msg.Width, msg.Height
 		m.width, m.height = w, h
+		h -= statusHeight
 		m.list.SetSize(m.leftWidth()-2, h-3)
 		m.resizePlot(m.rightWidth()-2, h-4)
 		m.listStyle = styles.NewStyle().
@@ -375,26 +938,103 @@ func (m *model) toggleTracking() {
 	m.mu.Unlock()
 }
 
+// nextWindow makes the next window drive the ranking and the plot.
+func (m *model) nextWindow() {
+	m.mu.Lock()
+	m.window = (m.window + 1) % len(config.Windows)
+	m.mu.Unlock()
+	sh := m.shards[0]
+	sh.mu.Lock()
+	n := sh.plotLength(m.window)
+	sh.mu.Unlock()
+	for i := range m.plotData {
+		m.plotData[i] = make([]float64, n)
+	}
+	m.plot.NumDataPoints = n
+	m.updateTopK()
+}
+
 func (m *model) updateListItemCountsFromSketch() {
 	m.mu.Lock()
+	if !m.scrollTime.IsZero() {
+		m.mu.Unlock()
+		return
+	}
 	for i := range m.listItems {
 		item := &m.listItems[i]
-		m.sketchMu.Lock()
-		item.Count = m.sketch.Count(item.Item)
-		m.sketchMu.Unlock()
+		item.Count = m.count(m.window, item.Item)
 	}
+	m.listCounts = m.windowCounts(m.listItems)
+	m.updateExactCounts()
+	m.updateTotals()
+	m.updateErrorBounds()
 	m.mu.Unlock()
 }
 
 func (m *model) updateTopK() {
-	m.sketchMu.Lock()
-	items := m.sketch.SortedSlice()
-	m.sketchMu.Unlock()
+	if snapshots := m.scrolledSnapshots(1); len(snapshots) > 0 {
+		m.showSnapshot(snapshots[0])
+		return
+	}
+	items, sizeBytes := m.topK(m.window)
+	var (
+		changes []itemChange
+		tree    []treeNode
+	)
+	switch {
+	case m.view == viewNew:
+		items = m.newItemList(m.window)
+	case m.view != viewTop:
+		items, changes = m.changes(m.window, m.view == viewFallers)
+	case config.Hierarchy != "":
+		items, tree = m.hierarchyItems(m.window)
+	}
+	counts := m.windowCounts(items)
+	distinct := m.distinctCounts(m.window, items)
+	quantiles := m.valueQuantiles(m.window, items)
+	exactShards, exactItems := m.exactMode(m.window)
+	isNew, firstSeen := m.newItems(items)
+	var fresh, untracked, learning int
+	if config.NewWindows > 0 {
+		fresh, untracked, learning = m.newItemsCounts()
+	}
 	m.mu.Lock()
 	m.listItems = items
+	m.listCounts = counts
+	m.listDistinct = distinct
+	m.listQuantiles = quantiles
+	m.listChanges = changes
+	m.listTree = tree
+	m.sizeBytes = sizeBytes
+	m.exactShards, m.exactItems = exactShards, exactItems
+	m.listNew, m.listFirstSeen = isNew, firstSeen
+	m.newFresh, m.newUntracked, m.newLearning = fresh, untracked, learning
+	m.updateExactCounts()
+	if m.view == viewTop {
+		m.updateAccuracy()
+	}
+	m.updateAnomalies()
+	m.updateTotals()
+	m.updateErrorBounds()
+	m.updateThroughput()
 	m.mu.Unlock()
 }
 
+// windowCounts returns the counts of the items in each window, or nil if there is only one window.
+func (m *model) windowCounts(items []heap.Item) [][]uint32 {
+	if len(config.Windows) == 1 {
+		return nil
+	}
+	counts := make([][]uint32, len(items))
+	for i, item := range items {
+		counts[i] = make([]uint32, len(config.Windows))
+		for w := range config.Windows {
+			counts[i][w] = m.count(w, item.Item)
+		}
+	}
+	return counts
+}
+
 func (m *model) resizePlot(w int, h int) {
 	p := plot.NewCanvas(w, h)
 	p.NumDataPoints = m.plot.NumDataPoints
@@ -416,12 +1056,49 @@ func (m *model) updateList(msg tui.Msg) tui.Cmd {
 	numDecimals := 1 + int(math.Ceil(math.Log10(float64(config.K+1))))
 	padToItemRankWidth := strings.Repeat(" ", numDecimals+1)
 	itemRankFormat := "#%-" + fmt.Sprint(numDecimals) + "d"
+	tiedRankFormat := "≈%-" + fmt.Sprint(numDecimals) + "d"
 	for i, item := range m.listItems {
-		items[i] = listItem{
+		rankFormat := itemRankFormat
+		if i < len(m.listTies) && m.listTies[i] {
+			rankFormat = tiedRankFormat
+		}
+		li := listItem{
 			DescriptionPrefix: padToItemRankWidth,
-			TitlePrefix:       fmt.Sprintf(itemRankFormat, i+1),
+			TitlePrefix:       fmt.Sprintf(rankFormat, i+1),
 			Item:              item,
+			ActiveWindow:      m.window,
+			RateUnit:          m.unit(),
+		}
+		li.Share, li.HasShare = m.share(item.Count)
+		if i < len(m.listCounts) {
+			li.WindowCounts = m.listCounts[i]
+		}
+		if i < len(m.listExact) {
+			li.HasExact, li.ExactCount = true, m.listExact[i]
+		}
+		if i < len(m.listErrors) {
+			li.HasError, li.Error = true, m.listErrors[i]
+		}
+		if i < len(m.listDistinct) {
+			li.HasDistinct, li.Distinct = true, m.listDistinct[i]
+		}
+		if i < len(m.listQuantiles) {
+			li.Quantiles = m.listQuantiles[i]
+		}
+		if i < len(m.listChanges) {
+			li.HasChange, li.Change = true, m.listChanges[i]
 		}
+		if i < len(m.listTree) {
+			li.HasTree, li.Tree = true, m.listTree[i]
+		}
+		if i < len(m.listAnomalies) && m.anomalous[item.Item] {
+			li.Anomalous, li.AnomalyScore = true, m.listAnomalies[i]
+		}
+		if i < len(m.listNew) && m.listNew[i] {
+			li.New, li.FirstSeen = true, m.listFirstSeen[i]
+			li.ShowFirstSeen = m.view == viewNew
+		}
+		items[i] = li
 		order[item.Item] = i
 	}
 	selected := m.list.SelectedItem()
@@ -438,12 +1115,13 @@ func (m *model) updateList(msg tui.Msg) tui.Cmd {
 
 func (m *model) updatePlot(_ tui.Msg) tui.Cmd {
 	logScale := m.logScale.Load()
+	unit := m.unit()
 
-	var highlight, dim plot.Color
+	var highlight, dim, alert plot.Color
 	if styles.DefaultRenderer().HasDarkBackground() {
-		highlight, dim = plot.Cyan, plot.DimGray
+		highlight, dim, alert = plot.Cyan, plot.DimGray, plot.Red
 	} else {
-		highlight, dim = plot.Black, plot.LightGray
+		highlight, dim, alert = plot.Black, plot.LightGray, plot.DarkRed
 	}
 
 	if len(m.listItems) == 0 {
@@ -453,23 +1131,56 @@ func (m *model) updatePlot(_ tui.Msg) tui.Cmd {
 	m.mu.Lock()
 	selected := m.list.Index()
 	items := m.listItems
+	anomalous := m.anomalous
 	m.mu.Unlock()
 
+	for len(m.plotData) <= len(items) { // the tree view can list more than k items
+		m.plotData = append(m.plotData, make([]float64, m.plot.NumDataPoints))
+	}
+	for len(m.plotLineColors) < len(m.plotData) {
+		m.plotLineColors = append(m.plotLineColors, dim)
+	}
 	for i := range m.plotData {
 		m.plotLineColors[i] = dim
 	}
+	var history map[string][]float64 // archived counts, if the view is scrolled back
+	if snapshots := m.scrolledSnapshots(m.plot.NumDataPoints); len(snapshots) > 0 {
+		history = archiveHistory(snapshots, m.window, items, m.plot.NumDataPoints)
+	}
+	var plotMax float64
 	for i := range items {
 		series := m.plotData[i]
 		item := items[(1+selected+i)%len(items)]
+		if anomalous[item.Item] {
+			m.plotLineColors[i] = alert
+		}
 
-		for j := range series {
-			m.sketchMu.Lock()
-			value := float64(m.countAtTimeOffset(item, j))
-			m.sketchMu.Unlock()
-			if logScale {
-				value = math.Log(max(1, value))
+		if history != nil {
+			copy(series, history[item.Item])
+		} else {
+			sh := m.shards[shardIndex(item.Item, len(m.shards))]
+			sh.mu.Lock()
+			sh.countHistory(m.window, item, series)
+			sh.mu.Unlock()
+		}
+		if unit != rateNone {
+			// sliding window series are counts per tick, decayed series are counts over the mean lifetime
+			perPoint := config.TickSize
+			if config.Mode == modeDecayed {
+				perPoint = config.Windows[0]
+			}
+			scale := float64(rateUnitDuration(unit)) / float64(perPoint)
+			for j := range series {
+				series[j] *= scale
+			}
+		}
+		for _, value := range series {
+			plotMax = max(plotMax, value)
+		}
+		if logScale {
+			for j, value := range series {
+				series[j] = math.Log(max(1, value))
 			}
-			series[len(series)-1-j] = value
 		}
 	}
 	n := len(items)
@@ -482,21 +1193,77 @@ func (m *model) updatePlot(_ tui.Msg) tui.Cmd {
 	m.plotData[n], m.plotData[n-1] = m.plotData[n-1], m.plotData[n]
 	m.mu.Lock()
 	m.plotLineColors, m.plot.LineColors = m.plot.LineColors, m.plotLineColors
+	m.plotMax = plotMax
 	m.mu.Unlock()
 	m.plot.Fill(m.plotData[:n+1])
 	return nil
 }
 
-func (m *model) countAtTimeOffset(item heap.Item, j int) uint32 {
-	var maxCount uint32
-	for k := range m.sketch.Depth {
-		b := m.sketch.Buckets[topk.BucketIndex(item.Item, k, m.sketch.Width)]
-		if b.Fingerprint == item.Fingerprint {
-			c := uint32(b.Counts[(int(b.First)+j)%len(b.Counts)])
-			maxCount = max(maxCount, c)
+// maxPlotLength is the number of plot points above which the window is resampled,
+// unless the sketch keeps more counters per bucket.
+const maxPlotLength = 256
+
+// plotLength returns the number of plot points for the sketch's window: one per tick, resampled to at most
+// maxPlotLength points (but no fewer than the bucket history length).
+func plotLength(s *sliding.Sketch) int {
+	return max(s.BucketHistoryLength, min(s.WindowSize, maxPlotLength))
+}
+
+// countHistory fills the series (oldest first) with an item's average count per tick in consecutive,
+// equally long time spans covering a window of the given number of ticks. The between function returns
+// the item's count in the tick ages [from, to), see countBetween.
+func countHistory(windowSize int, series []float64, between func(from, to float64) float64) {
+	ticksPerPoint := float64(windowSize) / float64(len(series))
+	for i := range series {
+		from, to := float64(i)*ticksPerPoint, float64(i+1)*ticksPerPoint
+		series[len(series)-1-i] = between(from, to) / ticksPerPoint
+	}
+}
+
+// countBetween returns the item's count in the tick ages [from, to), where age 0 is the current tick.
+// The caller must hold the sketch's shard lock.
+func countBetween(s *sliding.Sketch, item heap.Item, from, to float64) float64 {
+	var count float64
+	for k := range s.Depth {
+		bi := topk.BucketIndex(item.Item, k, s.Width)
+		if s.Buckets[bi].Fingerprint == item.Fingerprint {
+			count = max(count, bucketCount(s, bi, from, to))
+		}
+	}
+	return count
+}
+
+// bucketCount returns the count of the bucket with the given index in the tick ages [from, to).
+//
+// A bucket's current counter covers the ticks since the bucket was last aged, and each older counter
+// the ticks between two agings (N/d ticks for a window of N ticks and d counters per bucket).
+// Counters are spread evenly over the ticks they cover, so coarse histories are plotted as steps.
+func bucketCount(s *sliding.Sketch, bi int, from, to float64) float64 {
+	b := &s.Buckets[bi]
+	n, d := len(s.Buckets), s.BucketHistoryLength
+	agedPerTick := max(1, d*n/s.WindowSize)
+	agingPeriod := float64(n) / float64(agedPerTick)
+	// counter j covers the tick ages [start(j), start(j+1))
+	current := float64(((s.NextBucketToExpireIndex-1-bi)%n+n)%n/agedPerTick + 1)
+	start := func(j int) float64 {
+		if j == 0 {
+			return 0
+		}
+		return current + float64(j-1)*agingPeriod
+	}
+	j := 0
+	if from >= current {
+		j = 1 + int((from-current)/agingPeriod)
+	}
+	var sum float64
+	for ; j < d && start(j) < to; j++ {
+		lo, hi := start(j), start(j+1)
+		overlap := min(hi, to) - max(lo, from)
+		if overlap > 0 {
+			sum += float64(b.Counts[(int(b.First)+j)%d]) * overlap / (hi - lo)
 		}
 	}
-	return maxCount
+	return sum
 }
 
 func (m *model) View() string {
@@ -515,19 +1282,104 @@ func (m *model) View() string {
 	} else {
 		linColor = selectedFg
 	}
-	linLog := linColor.Render("LIN") + " " + logColor.Render("LOG")
+	controls := linColor.Render("LIN") + " " + logColor.Render("LOG")
+	if unit := m.unit(); unit != rateNone {
+		m.mu.Lock()
+		plotMax := m.plotMax
+		m.mu.Unlock()
+		controls += " " + selectedFg.Render("↑"+formatNumber(plotMax)+"/"+unit)
+	}
+	m.mu.Lock()
+	scrollTime, latestTick := m.scrollTime, m.latestTick
+	listed, fresh := len(m.listItems), m.newFresh
+	m.mu.Unlock()
+	switch {
+	case !scrollTime.IsZero():
+		controls += " " + selectedFg.Render("ARCHIVE -"+formatDuration(latestTick.Sub(scrollTime)))
+	case m.view == viewNew && fresh > listed:
+		// the view lists the k new items with the largest counts
+		controls += " " + selectedFg.Render(fmt.Sprintf("NEW %d/%s", listed, formatSI(float64(fresh))))
+	case m.view != viewTop:
+		controls += " " + selectedFg.Render(viewNames[m.view])
+	}
+	if len(config.Windows) > 1 {
+		for i, w := range config.Windows {
+			windowColor := borderFg
+			if i == m.window {
+				windowColor = selectedFg
+			}
+			controls += " " + windowColor.Render(formatDuration(w))
+		}
+	}
 
 	labels := ""
 	if !m.latestTick.IsZero() {
 		w := m.rightWidth() - 3
-		leftLabel := m.latestTick.Add(-config.WindowSize).UTC().Format(time.RFC3339)
-		rightLabel := m.latestTick.UTC().Format(time.RFC3339)
-		space := strings.Repeat(" ", (w-len(leftLabel)-len(rightLabel)-1)/2-len("LIN LOG")/2)
-		labels = " " + leftLabel + space + linLog + space + borderFg.Render(rightLabel)
+		span := config.Windows[m.window]
+		if config.Mode == modeDecayed {
+			span = decayedHistoryLength * config.TickSize
+		}
+		end := m.latestTick
+		if !scrollTime.IsZero() {
+			end = scrollTime
+			span = time.Duration(m.plot.NumDataPoints-1) * config.ArchiveInterval
+		}
+		leftLabel := end.Add(-span).UTC().Format(time.RFC3339)
+		rightLabel := end.UTC().Format(time.RFC3339)
+		space := strings.Repeat(" ", max(0, (w-len(leftLabel)-len(rightLabel)-1)/2-styles.Width(controls)/2))
+		labels = " " + leftLabel + space + controls + space + borderFg.Render(rightLabel)
 	}
 	right := plotStyle.Render(styles.JoinVertical(styles.Top, plot, labels))
 	view := styles.JoinHorizontal(styles.Top, left, right)
-	return styles.JoinVertical(styles.Left, view, m.help.View(keys))
+	return styles.JoinVertical(styles.Left, view, m.status(), m.help.View(keys))
+}
+
+// statusHeight is the height of the status line.
+const statusHeight = 1
+
+// status returns the status line shown between the main view and the help.
+func (m *model) status() string {
+	parts := []string{m.totalsStatus(), m.throughputStatus(), m.queueStatus()}
+	if config.Memory > 0 {
+		parts = append(parts, m.memoryStatus())
+	}
+	if config.ExactBelow > 0 {
+		parts = append(parts, m.exactModeStatus())
+	}
+	if config.ShadowExact {
+		parts = append(parts, m.exactStatus())
+	}
+	if config.Value != "" {
+		parts = append(parts, m.quantileStatus())
+	}
+	if config.NewWindows > 0 {
+		parts = append(parts, m.newItemsStatus())
+	}
+	if m.archive != nil {
+		parts = append(parts, m.archiveStatus())
+	}
+	if config.Format == formatPrometheus {
+		parts = append(parts, m.prometheusStatus())
+	}
+	if config.Format == formatMerge {
+		parts = append([]string{m.mergeStatus()}, parts...)
+	}
+	if (config.StateFile != "" || config.StatePush != "") && config.StateInterval > 0 {
+		parts = append(parts, m.checkpointStatus())
+	}
+	return " " + borderFg.Render(strings.Join(parts, " • "))
+}
+
+// formatDuration formats durations without trailing zero units, e.g. 1h instead of 1h0m0s.
+func formatDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
 }
 
 func emptyPlot(m *model) strings.Builder {
@@ -548,35 +1400,108 @@ func emptyPlot(m *model) strings.Builder {
 type listItem struct {
 	DescriptionPrefix string
 	TitlePrefix       string
+	WindowCounts      []uint32
+	ActiveWindow      int
+	RateUnit          string // see rateUnits
+	HasShare          bool
+	Share             float64
+	HasError          bool
+	Error             float64 // error bound of the active window's count
+	HasExact          bool
+	HasDistinct       bool
+	Distinct          float64
+	Quantiles         []float64 // value quantiles, see quantileLevels
+	HasChange         bool
+	Change            itemChange
+	Anomalous         bool
+	AnomalyScore      float64
+	New               bool
+	FirstSeen         int // ticks since the new item was first seen
+	ShowFirstSeen     bool
+	HasTree           bool
+	Tree              treeNode
+	ExactCount        uint32
 	heap.Item
 }
 
 func (i listItem) Title() string {
-	return fmt.Sprintf("%s %s", i.TitlePrefix, i.Item.Item)
+	name := i.Item.Item
+	if i.HasTree {
+		name = treeTitle(i.Tree, name)
+	}
+	if i.New {
+		name += " NEW"
+	}
+	if i.Anomalous {
+		return fmt.Sprintf("%s %s %s", i.TitlePrefix, name, formatAnomaly(i.AnomalyScore))
+	}
+	return fmt.Sprintf("%s %s", i.TitlePrefix, name)
 }
 func (i listItem) Description() string {
-	return fmt.Sprintf("%s %d", i.DescriptionPrefix, i.Count)
+	var sb strings.Builder
+	sb.WriteString(i.DescriptionPrefix)
+	errorBound := "" // the upper end of the range of the true count
+	if i.HasError && i.Error > 0 {
+		errorBound = "…" + formatCount(float64(i.Count)+i.Error, config.Windows[i.ActiveWindow], i.RateUnit)
+	}
+	if len(i.WindowCounts) == 0 {
+		sb.WriteString(" " + formatCount(float64(i.Count), config.Windows[i.ActiveWindow], i.RateUnit) + errorBound)
+	}
+	for w, count := range i.WindowCounts {
+		formatted := formatCount(float64(count), config.Windows[w], i.RateUnit)
+		if w == i.ActiveWindow {
+			formatted += errorBound
+			fmt.Fprintf(&sb, " [%s:%s]", formatDuration(config.Windows[w]), formatted)
+		} else {
+			fmt.Fprintf(&sb, " %s:%s", formatDuration(config.Windows[w]), formatted)
+		}
+	}
+	if i.HasShare {
+		sb.WriteString(" " + formatShare(i.Share))
+	}
+	if i.HasDistinct {
+		sb.WriteString(" " + formatDistinct(i.Distinct))
+	}
+	if i.Quantiles != nil {
+		sb.WriteString(" " + formatQuantiles(listQuantileLevels, i.Quantiles))
+	}
+	if i.HasExact {
+		sb.WriteString(" " + formatError(i.Count, i.ExactCount))
+	}
+	if i.HasChange {
+		sb.WriteString(" Δ" + formatChange(i.Change))
+	}
+	if i.ShowFirstSeen {
+		sb.WriteString(" " + formatFirstSeen(i.FirstSeen))
+	}
+	return sb.String()
 }
 func (i listItem) FilterValue() string { return i.Item.Item }
 
 func (k keyMap) ShortHelp() []key.Binding {
-	return []key.Binding{k.Quit, k.Up, k.Down, k.Track, k.Scale}
+	return []key.Binding{k.Quit, k.Up, k.Down, k.Track, k.Scale, k.Rate, k.Window, k.Changes, k.Expand, k.Collapse, k.Archive}
 }
 
 func (k keyMap) FullHelp() [][]key.Binding {
 	return [][]key.Binding{
 		{k.Quit},
-		{k.Up, k.Down, k.Track, k.Scale},
+		{k.Up, k.Down, k.Track, k.Scale, k.Rate, k.Window, k.Changes, k.Expand, k.Collapse, k.Archive},
 	}
 }
 
 type keyMap struct {
-	Track key.Binding
-	Scale key.Binding
-	Up    key.Binding
-	Down  key.Binding
-	Help  key.Binding
-	Quit  key.Binding
+	Track    key.Binding
+	Scale    key.Binding
+	Rate     key.Binding
+	Window   key.Binding
+	Changes  key.Binding
+	Expand   key.Binding
+	Collapse key.Binding
+	Archive  key.Binding
+	Up       key.Binding
+	Down     key.Binding
+	Help     key.Binding
+	Quit     key.Binding
 }
 
 var keys = keyMap{
@@ -588,6 +1513,30 @@ var keys = keyMap{
 		key.WithKeys("s"),
 		key.WithHelp("s", "log/lin"),
 	),
+	Rate: key.NewBinding(
+		key.WithKeys("r"),
+		key.WithHelp("r", "count/rate"),
+	),
+	Window: key.NewBinding(
+		key.WithKeys("w"),
+		key.WithHelp("w", "window"),
+	),
+	Changes: key.NewBinding(
+		key.WithKeys("c"),
+		key.WithHelp("c", "top/risers/fallers"),
+	),
+	Expand: key.NewBinding(
+		key.WithKeys("right", "enter"),
+		key.WithHelp("→/enter", "expand"),
+	),
+	Collapse: key.NewBinding(
+		key.WithKeys("left"),
+		key.WithHelp("←", "collapse"),
+	),
+	Archive: key.NewBinding(
+		key.WithKeys("[", "]"),
+		key.WithHelp("[/]", "older/newer"),
+	),
 	Quit: key.NewBinding(
 		key.WithKeys("q", "ctrl+c"),
 		key.WithHelp("q/ctrl+c", "quit"),
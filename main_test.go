@@ -0,0 +1,125 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/keilerkonzept/topk/heap"
+	"github.com/keilerkonzept/topk/sliding"
+)
+
+// testModel returns a model with the default configuration (changed by the given function), which is restored
+// when the test ends.
+func testModel(t testing.TB, configure func(*Config)) *model {
+	t.Helper()
+	saved := config
+	t.Cleanup(func() { config = saved })
+	config.Windows = []time.Duration{config.WindowSize}
+	if configure != nil {
+		configure(&config)
+	}
+	return newModel(newShards(config.Shards), nil)
+}
+
+// queuedCounts removes the queued items from the shards' queues, and returns their counts.
+func queuedCounts(m *model) map[string]uint32 {
+	counts := make(map[string]uint32)
+	for _, sh := range m.shards {
+		for len(sh.queue) > 0 {
+			if op := <-sh.queue; op.item != "" {
+				counts[op.item] += op.count
+			}
+		}
+	}
+	return counts
+}
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		timestamp any
+		want      time.Time
+	}{
+		{int64(1700000000), time.Unix(1700000000, 0)},
+		{uint64(1700000000), time.Unix(1700000000, 0)},
+		{float64(1700000000.7), time.Unix(1700000000, 0)},
+		{"2023-11-14T22:13:20Z", time.Unix(1700000000, 0)},
+		{time.Unix(1700000000, 5), time.Unix(1700000000, 5)},
+		{"yesterday", time.Time{}},
+		{nil, time.Time{}},
+	}
+	for _, tt := range tests {
+		if got := parseTimestamp(tt.timestamp); !got.Equal(tt.want) {
+			t.Errorf("parseTimestamp(%#v) = %v, want %v", tt.timestamp, got, tt.want)
+		}
+	}
+}
+
+// flushQueues applies the shards' queued operations, and replaces their queues with empty ones.
+func flushQueues(m *model) {
+	for _, sh := range m.shards {
+		close(sh.queue)
+		m.applyQueued(sh)
+		sh.queue = make(chan ingestOp, config.QueueSize)
+	}
+}
+
+func TestBucketCount(t *testing.T) {
+	// one row of 4 buckets; a full history has a counter per tick, a coarse one ages each bucket every 4 ticks
+	full := sliding.New(1, 4, sliding.WithWidth(4), sliding.WithDepth(1))
+	full.Buckets[2].Counts, full.Buckets[2].First = []uint32{3, 2, 1, 5}, 3
+	coarse := sliding.New(1, 8, sliding.WithWidth(4), sliding.WithDepth(1), sliding.WithBucketHistoryLength(2))
+	// bucket 1 was aged 3 ticks ago and covers [0,3) and [3,7); bucket 3 was aged with the latest tick
+	coarse.Buckets[1].Counts, coarse.Buckets[1].First = []uint32{8, 6}, 1
+	coarse.Buckets[3].Counts, coarse.Buckets[3].First = []uint32{4, 0}, 0
+
+	tests := []struct {
+		name     string
+		s        *sliding.Sketch
+		bucket   int
+		from, to float64
+		want     float64
+	}{
+		{"full current", full, 2, 0, 1, 5},
+		{"full oldest", full, 2, 3, 4, 1},
+		{"full fractional", full, 2, 0.5, 1.5, 2.5 + 1.5},
+		{"full window", full, 2, 0, 4, 11},
+		{"full beyond window", full, 2, 4, 8, 0},
+		{"coarse current", coarse, 1, 0, 3, 6},
+		{"coarse current tick", coarse, 1, 0, 1, 2},
+		{"coarse older", coarse, 1, 3, 7, 8},
+		{"coarse older tick", coarse, 1, 5, 6, 2},
+		{"coarse across counters", coarse, 1, 2, 4, 2 + 2},
+		{"coarse window", coarse, 1, 0, 8, 14},
+		{"coarse beyond history", coarse, 1, 7, 8, 0},
+		{"coarse just aged", coarse, 3, 0, 1, 4},
+		{"coarse just aged older", coarse, 3, 1, 5, 0},
+	}
+	for _, tt := range tests {
+		if got := bucketCount(tt.s, tt.bucket, tt.from, tt.to); got != tt.want {
+			t.Errorf("%s: bucketCount(%d, %v, %v) = %v, want %v", tt.name, tt.bucket, tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestNextWindow(t *testing.T) {
+	m := testModel(t, func(c *Config) {
+		c.Shards = 1
+		c.Windows = []time.Duration{4 * c.TickSize, 8 * c.TickSize}
+	})
+	m.add(record{Item: "a", Count: 5})
+	m.queueTicks(5)
+	m.add(record{Item: "a", Count: 3})
+	flushQueues(m)
+
+	if got, want := m.windowCounts([]heap.Item{{Item: "a"}}), [][]uint32{{3, 8}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got window counts %v, want %v", got, want)
+	}
+	for _, want := range []uint32{3, 8, 3} {
+		m.updateTopK()
+		if len(m.listItems) != 1 || m.listItems[0].Count != want {
+			t.Errorf("window %d: got list items %v, want a with count %d", m.window, m.listItems, want)
+		}
+		m.nextWindow()
+	}
+}
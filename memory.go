@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keilerkonzept/topk"
+	"github.com/keilerkonzept/topk/sliding"
+)
+
+// heapKeyBytesEstimate is the assumed memory per top-K heap entry for the item string and index,
+// which the sketch's SizeBytes only accounts for once items are stored.
+const heapKeyBytesEstimate = 64
+
+// widthForMemory returns the largest sketch width for which the sketches of all windows and shards fit into the budget.
+// SizeBytes is linear in the width, so it is measured at widths 1 and 2 and extrapolated.
+func widthForMemory(budget int, windows []time.Duration) (int, error) {
+	var fixed, perColumn int
+	for _, w := range windows {
+		size1 := sliding.New(sketchK(), int(w/config.TickSize), sketchOptions(1)...).SizeBytes()
+		size2 := sliding.New(sketchK(), int(w/config.TickSize), sketchOptions(2)...).SizeBytes()
+		if config.Mode == modeDecayed {
+			size1 = topk.New(sketchK(), decayedSketchOptions(1)...).SizeBytes()
+			size2 = topk.New(sketchK(), decayedSketchOptions(2)...).SizeBytes()
+			fixed += sketchK() * decayedHistoryLength * 4 // uint32 history of each heap item
+		}
+		perColumn += size2 - size1
+		fixed += size1 - (size2 - size1) + sketchK()*heapKeyBytesEstimate
+	}
+	width := (budget/config.Shards - fixed) / perColumn
+	if width < 1 {
+		return 0, fmt.Errorf("memory budget %s is too small, need at least %s", formatBytes(budget), formatBytes(config.Shards*(fixed+perColumn)))
+	}
+	return width * config.Shards, nil
+}
+
+func (m *model) memoryStatus() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return fmt.Sprintf("sketch %s of %s (width %d, depth %d)", formatBytes(m.sizeBytes), formatBytes(config.Memory), config.Width, config.Depth)
+}
+
+var byteSizeUnits = []struct {
+	suffix string
+	size   int
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40},
+	{"B", 1},
+}
+
+// parseByteSize parses sizes like 64MiB, 1.5G, 500MB or 4096.
+func parseByteSize(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	unit := 1
+	for _, u := range byteSizeUnits {
+		if strings.HasSuffix(strings.ToUpper(s), strings.ToUpper(u.suffix)) {
+			s, unit = strings.TrimSpace(s[:len(s)-len(u.suffix)]), u.size
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 || v*float64(unit) > math.MaxInt {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int(v * float64(unit)), nil
+}
+
+// formatBytes formats a size using binary units, e.g. 63.8MiB.
+func formatBytes(n int) string {
+	if n < 1<<10 {
+		return fmt.Sprintf("%dB", n)
+	}
+	v := float64(n)
+	for _, unit := range []string{"KiB", "MiB", "GiB", "TiB"} {
+		v /= 1 << 10
+		if v < 1<<10 {
+			return fmt.Sprintf("%.1f%s", v, unit)
+		}
+	}
+	return fmt.Sprintf("%.1fPiB", v/(1<<10))
+}
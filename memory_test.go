@@ -0,0 +1,123 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keilerkonzept/topk"
+	"github.com/keilerkonzept/topk/sliding"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+	}{
+		{"4096", 4096},
+		{"100B", 100},
+		{"64KiB", 64 << 10},
+		{"64kib", 64 << 10},
+		{"64MiB", 64 << 20},
+		{"1.5G", 3 << 29},
+		{"2GiB", 2 << 30},
+		{"1TiB", 1 << 40},
+		{"500MB", 500e6},
+		{"2kb", 2000},
+		{"3T", 3 << 40},
+		{" 16 M ", 16 << 20},
+		{"0", 0},
+	}
+	for _, tt := range tests {
+		if got, err := parseByteSize(tt.s); err != nil || got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, %v, want %d", tt.s, got, err, tt.want)
+		}
+	}
+	for _, s := range []string{"", "MiB", "-1MiB", "1XB", "ten", "1e30TB"} {
+		if got, err := parseByteSize(s); err == nil {
+			t.Errorf("parseByteSize(%q) = %d, want an error", s, got)
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{0, "0B"},
+		{1023, "1023B"},
+		{1024, "1.0KiB"},
+		{1536, "1.5KiB"},
+		{64 << 20, "64.0MiB"},
+		{3 << 29, "1.5GiB"},
+		{1 << 40, "1.0TiB"},
+		{1 << 50, "1.0PiB"},
+	}
+	for _, tt := range tests {
+		if got := formatBytes(tt.n); got != tt.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+// sketchesBytes returns the memory of all windows' and shards' sketches of the given total width, as estimated by
+// widthForMemory.
+func sketchesBytes(width int, windows []time.Duration) int {
+	var size int
+	for _, w := range windows {
+		if config.Mode == modeDecayed {
+			size += topk.New(sketchK(), decayedSketchOptions(width/config.Shards)...).SizeBytes()
+			size += sketchK() * decayedHistoryLength * 4
+		} else {
+			size += sliding.New(sketchK(), int(w/config.TickSize), sketchOptions(width/config.Shards)...).SizeBytes()
+		}
+		size += sketchK() * heapKeyBytesEstimate
+	}
+	return size * config.Shards
+}
+
+func TestWidthForMemory(t *testing.T) {
+	tests := []struct {
+		name          string
+		budget        int
+		depth, shards int
+		history       int
+		mode          string
+		windows       []time.Duration
+	}{
+		{"one window", 64 << 20, 3, 1, 0, modeSliding, []time.Duration{time.Minute}},
+		{"deeper", 64 << 20, 5, 1, 0, modeSliding, []time.Duration{time.Minute}},
+		{"shards", 64 << 20, 3, 4, 0, modeSliding, []time.Duration{time.Minute}},
+		{"windows", 256 << 20, 3, 2, 0, modeSliding, []time.Duration{time.Minute, time.Hour}},
+		{"history length", 64 << 20, 3, 1, 60, modeSliding, []time.Duration{time.Hour}},
+		{"decayed", 16 << 20, 4, 2, 0, modeDecayed, []time.Duration{time.Minute}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testModel(t, func(c *Config) {
+				c.Depth = tt.depth
+				c.Shards = tt.shards
+				c.HistoryLength = tt.history
+				c.Mode = tt.mode
+			})
+			width, err := widthForMemory(tt.budget, tt.windows)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if width%tt.shards != 0 {
+				t.Errorf("got width %d, want a multiple of %d shards", width, tt.shards)
+			}
+			if size := sketchesBytes(width, tt.windows); size > tt.budget {
+				t.Errorf("got width %d using %s, more than the budget of %s", width, formatBytes(size), formatBytes(tt.budget))
+			}
+			if size := sketchesBytes(width+tt.shards, tt.windows); size <= tt.budget {
+				t.Errorf("got width %d, but one more column per shard still fits: %s of %s", width, formatBytes(size), formatBytes(tt.budget))
+			}
+		})
+	}
+
+	testModel(t, nil)
+	if _, err := widthForMemory(1<<10, []time.Duration{time.Hour}); err == nil {
+		t.Error("got no error for a budget below a single column")
+	}
+}
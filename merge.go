@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keilerkonzept/topk"
+	"github.com/keilerkonzept/topk/sliding"
+)
+
+// mergeSnapshots periodically merges the latest sketch snapshot of each source (files and pushed snapshots)
+// into the model's sketches.
+func (m *model) mergeSnapshots() {
+	var (
+		mu       sync.Mutex
+		received = make(map[string]*state)    // latest accepted state per pushing instance
+		rejected = make(map[string]rejection) // latest rejection per pushing instance
+	)
+	if m.listener != nil {
+		go m.receiveSnapshots(func(source string, st *state, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err != nil:
+				rejected[source] = rejection{reason: err.Error(), at: time.Now()}
+			case st == nil:
+				delete(received, source)
+			default:
+				received[source] = st
+				delete(rejected, source)
+			}
+		})
+	}
+	maxAge := time.Duration(maxOf(windowTicks())) * config.TickSize
+	ticker := time.NewTicker(config.MergeInterval)
+	for ; ; <-ticker.C {
+		var (
+			snapshots []*state
+			sources   []string
+			rejects   []string
+		)
+		for _, pattern := range strings.Split(config.MergeFiles, ",") {
+			if pattern == "" {
+				continue
+			}
+			paths, _ := filepath.Glob(pattern)
+			for _, path := range paths {
+				st, err := loadState(path, m.shards)
+				switch {
+				case err != nil:
+					rejects = append(rejects, err.Error())
+				case st != nil:
+					snapshots, sources = append(snapshots, st), append(sources, path)
+				}
+			}
+		}
+		var latest time.Time
+		for _, st := range snapshots {
+			latest = maxTime(latest, st.LatestTick)
+		}
+		mu.Lock()
+		for _, st := range received {
+			latest = maxTime(latest, st.LatestTick)
+		}
+		for source, st := range received {
+			if latest.Sub(st.LatestTick) > maxAge { // all of its counts are out of the window
+				delete(received, source)
+				continue
+			}
+			snapshots, sources = append(snapshots, st), append(sources, source)
+		}
+		for source, r := range rejected {
+			if time.Since(r.at) > maxAge {
+				delete(rejected, source)
+				continue
+			}
+			rejects = append(rejects, source+": "+r.reason)
+		}
+		mu.Unlock()
+		snapshots, misaligned := alignedStates(snapshots, sources)
+		rejects = append(rejects, misaligned...)
+		sort.Strings(rejects)
+		m.mu.Lock()
+		m.mergeSources, m.mergeRejects = len(snapshots), rejects
+		m.mu.Unlock()
+		if len(snapshots) == 0 {
+			continue
+		}
+		m.installMerged(mergeStates(snapshots))
+	}
+}
+
+// rejection is the reason why a pushed state could not be merged. Rejections are shown for one (longest) window.
+type rejection struct {
+	reason string
+	at     time.Time
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+// receiveSnapshots accepts connections on the model's listener, each sending one or more gob-encoded states.
+// Each state is passed on with its source, the writer's host name and remote address (which is the same for all
+// states pushed over a connection), or with the reason why it is incompatible. When a connection is closed,
+// its sources are passed on with neither a state nor an error, since a reconnecting writer has a new source.
+func (m *model) receiveSnapshots(receive func(source string, st *state, err error)) {
+	for {
+		conn, err := m.listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			sources := make(map[string]bool)
+			defer func() {
+				for source := range sources {
+					receive(source, nil, nil)
+				}
+			}()
+			dec := gob.NewDecoder(bufio.NewReader(conn))
+			for {
+				var st state
+				if err := dec.Decode(&st); err != nil {
+					var netErr net.Error
+					if err != io.EOF && !errors.As(err, &netErr) {
+						receive(conn.RemoteAddr().String(), nil, err)
+					}
+					return
+				}
+				source := conn.RemoteAddr().String()
+				if st.Source != "" {
+					source = st.Source + "@" + source
+				}
+				if err := st.check(m.shards); err != nil {
+					receive(source, nil, err)
+					continue
+				}
+				sources[source] = true
+				receive(source, &st, nil)
+			}
+		}()
+	}
+}
+
+func (m *model) mergeStatus() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status := fmt.Sprintf("merged %d sources", m.mergeSources)
+	if len(m.mergeRejects) > 0 { // first, so that the renderer does not cut the reasons off
+		status = fmt.Sprintf("rejected %d: %s; %s", len(m.mergeRejects), strings.Join(m.mergeRejects, "; "), status)
+	}
+	return status
+}
+
+// installMerged replaces the model's sketches with the merged ones.
+func (m *model) installMerged(st *state) {
+	m.installSketches(st.Shards)
+	m.totals.Restore(st.Totals)
+	m.mu.Lock()
+	m.latestTick = st.LatestTick
+	m.mu.Unlock()
+	m.archiveTick(st.LatestTick)
+}
+
+// mergeStates merges compatible states into a new state with the latest of their ticks.
+func mergeStates(states []*state) *state {
+	var latest time.Time
+	for _, st := range states {
+		if st.LatestTick.After(latest) {
+			latest = st.LatestTick
+		}
+	}
+	lags := make([]int, len(states))
+	for i, st := range states {
+		lags[i] = stateLag(latest, st)
+	}
+	out := &state{
+		Version:    stateVersion,
+		LatestTick: latest,
+		TickSize:   config.TickSize,
+		Windows:    config.Windows,
+		Shards:     make([][]*sliding.Sketch, config.Shards),
+	}
+	totals := make([][]streamTick, len(states))
+	for i, st := range states {
+		totals[i] = st.Totals
+	}
+	out.Totals = mergeTotals(totals, lags)
+	snapshots := make([]*sliding.Sketch, len(states))
+	for sh := range out.Shards {
+		out.Shards[sh] = make([]*sliding.Sketch, len(config.Windows))
+		for w, window := range config.Windows {
+			for i, st := range states {
+				snapshots[i] = st.Shards[sh][w]
+			}
+			out.Shards[sh][w] = mergeSketches(newSketch(window), snapshots, lags)
+		}
+	}
+	return out
+}
+
+// stateLag returns the number of ticks the state lags behind the latest tick.
+func stateLag(latest time.Time, st *state) int {
+	if st.LatestTick.IsZero() {
+		return 0
+	}
+	return int(latest.Sub(st.LatestTick) / config.TickSize)
+}
+
+// alignedStates returns the states whose bucket histories can be aligned with the latest state's (see checkAlignment),
+// and the rejections of the others, by source.
+func alignedStates(states []*state, sources []string) (aligned []*state, rejects []string) {
+	latest := 0
+	for i, st := range states {
+		if st.LatestTick.After(states[latest].LatestTick) {
+			latest = i
+		}
+	}
+	for i, st := range states {
+		if err := checkAlignment(states[latest], st); err != nil {
+			rejects = append(rejects, sources[i]+": "+err.Error())
+			continue
+		}
+		aligned = append(aligned, st)
+	}
+	return aligned, rejects
+}
+
+// checkAlignment returns an error if the state's bucket histories cannot be aligned exactly with the latest state's.
+// Each tick ages the next d*n/N of a sketch's n buckets (for d counters per bucket and a window of N ticks).
+// Unless that is all of them, the ticks must age a whole number of buckets that divides n, so that each bucket is
+// aged every n/(d*n/N) ticks, and the state's aging must be in phase with the latest state's: the bucket it ages next
+// is the one the latest state aged the state's lag ago.
+func checkAlignment(latest, st *state) error {
+	lag := stateLag(latest.LatestTick, st)
+	for sh, sketches := range st.Shards {
+		for w, s := range sketches {
+			next := latest.Shards[sh][w].NextBucketToExpireIndex
+			if lag == 0 && s.NextBucketToExpireIndex == next {
+				continue
+			}
+			n, d := len(s.Buckets), s.BucketHistoryLength
+			aged := d * n / s.WindowSize
+			if aged == 0 || d*n%s.WindowSize != 0 || n%aged != 0 {
+				return fmt.Errorf("%d ticks behind, and -history-length %d does not age whole bucket groups per tick (%d buckets, window of %d ticks)",
+					lag, d, n, s.WindowSize)
+			}
+			if (s.NextBucketToExpireIndex+lag*aged)%n != next {
+				return fmt.Errorf("bucket aging out of phase with the latest state (%d ticks behind, -history-length %d)", lag, d)
+			}
+		}
+	}
+	return nil
+}
+
+// mergeSketches merges the snapshots into the given empty sketch.
+//
+// The snapshots' bucket histories are aligned by age, with each snapshot's history shifted by its lag (in ticks)
+// behind the latest snapshot, see checkAlignment. Buckets with the same fingerprint are summed. On fingerprint collisions, the bucket
+// with the larger count wins, and the smaller count is subtracted from it (HeavyKeeper-style).
+// The top-K heap is then re-derived from the union of the snapshots' heap items.
+func mergeSketches(merged *sliding.Sketch, snapshots []*sliding.Sketch, lags []int) *sliding.Sketch {
+	n, d := len(merged.Buckets), merged.BucketHistoryLength
+	agedPerTick := max(1, d*n/merged.WindowSize)
+	agingPeriod := n / agedPerTick // ticks between two agings of a bucket
+	aligned := make([]uint32, d)
+	freshest := 0
+	for i := range snapshots {
+		if lags[i] < lags[freshest] {
+			freshest = i
+		}
+	}
+	for bi := range merged.Buckets {
+		mb := &merged.Buckets[bi]
+		for si, s := range snapshots {
+			b := &s.Buckets[bi]
+			if b.CountsSum == 0 {
+				continue
+			}
+			// the bucket's counter j covers the same ticks as the latest snapshot's counter j+shift
+			periods := ((s.NextBucketToExpireIndex-1-bi)%n + n) % n / agedPerTick
+			shift := (periods + lags[si]) / agingPeriod
+			var sum uint32
+			clear(aligned)
+			for j := 0; j+shift < d; j++ {
+				aligned[j+shift] = b.Counts[(int(b.First)+j)%d]
+				sum += aligned[j+shift]
+			}
+			if sum == 0 {
+				continue
+			}
+			switch {
+			case mb.CountsSum == 0 || mb.Fingerprint == b.Fingerprint:
+				mb.Fingerprint = b.Fingerprint
+				for j, c := range aligned {
+					mb.Counts[j] += c
+				}
+			case sum > mb.CountsSum:
+				mb.Fingerprint = b.Fingerprint
+				for j, c := range aligned {
+					mb.Counts[j] = c - min(c, mb.Counts[j])
+				}
+			default:
+				for j, c := range aligned {
+					mb.Counts[j] -= min(c, mb.Counts[j])
+				}
+			}
+			mb.CountsSum = 0
+			for _, c := range mb.Counts {
+				mb.CountsSum += c
+			}
+		}
+	}
+	merged.NextBucketToExpireIndex = snapshots[freshest].NextBucketToExpireIndex
+
+	for _, s := range snapshots {
+		for _, item := range s.Heap.Items {
+			if merged.Heap.Contains(item.Item) {
+				continue
+			}
+			var count uint32
+			for k := range merged.Depth {
+				b := &merged.Buckets[topk.BucketIndex(item.Item, k, merged.Width)]
+				if b.Fingerprint == item.Fingerprint {
+					count = max(count, b.CountsSum)
+				}
+			}
+			if count > 0 {
+				merged.Heap.Update(item.Item, item.Fingerprint, count)
+			}
+		}
+	}
+	return merged
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/keilerkonzept/topk"
+	"github.com/keilerkonzept/topk/heap"
+	"github.com/keilerkonzept/topk/sliding"
+)
+
+func TestMergeSketches(t *testing.T) {
+	testModel(t, func(c *Config) { c.Windows = []time.Duration{4 * time.Second} })
+	window := config.Windows[0]
+	latest := newSketch(window)
+	latest.Add("a", 3)
+	latest.Tick()
+	latest.Add("a", 2)
+	latest.Add("b", 1)
+	lagging := newSketch(window) // one tick behind latest
+	lagging.Add("a", 5)
+	lagging.Add("c", 4)
+	expired := newSketch(window) // a full window behind latest
+	expired.Add("a", 100)
+	expired.Add("d", 100)
+
+	merged := mergeSketches(newSketch(window), []*sliding.Sketch{latest, lagging, expired}, []int{0, 1, 4})
+
+	tests := []struct {
+		item   string
+		counts []float64 // by tick age
+	}{
+		{"a", []float64{2, 3 + 5, 0, 0}},
+		{"b", []float64{1, 0, 0, 0}},
+		{"c", []float64{0, 4, 0, 0}},
+		{"d", []float64{0, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		item := heap.Item{Item: tt.item, Fingerprint: topk.Fingerprint(tt.item)}
+		for age, want := range tt.counts {
+			if got := countBetween(merged, item, float64(age), float64(age+1)); got != want {
+				t.Errorf("%s at age %d: got %v, want %v", tt.item, age, got, want)
+			}
+		}
+	}
+	var top []string
+	for _, item := range merged.SortedSlice() {
+		top = append(top, item.Item)
+	}
+	if got, want := strings.Join(top, ","), "a,c,b"; got != want {
+		t.Errorf("got top-k %s, want %s", got, want)
+	}
+
+	merged.Ticks(2) // the lagging snapshot's counts are now 3 ticks old, and expire with the next tick
+	if got := merged.Count("c"); got != 4 {
+		t.Errorf("got count %d for c after 2 ticks, want 4", got)
+	}
+	merged.Tick()
+	if got := merged.Count("c"); got != 0 {
+		t.Errorf("got count %d for c after 3 ticks, want 0", got)
+	}
+	if got := merged.Count("a"); got != 2 {
+		t.Errorf("got count %d for a after 3 ticks, want 2", got)
+	}
+}
+
+func TestMergeSketchesHistoryLength(t *testing.T) {
+	// 2 counters per bucket for a window of 4 ticks: each tick ages half of the buckets, each bucket every 2 ticks
+	testModel(t, func(c *Config) {
+		c.Windows = []time.Duration{4 * time.Second}
+		c.HistoryLength = 2
+		c.Width = 64
+		c.Depth = 2
+	})
+	window := config.Windows[0]
+	for _, lag := range []int{1, 2, 3} {
+		// two instances started at the same time; the lagging one stopped after its first tick
+		lagging := newSketch(window)
+		lagging.Add("a", 5)
+		lagging.Tick()
+		latest := newSketch(window)
+		latest.Add("b", 3)
+		// a single instance that counted both
+		want := newSketch(window)
+		want.Add("a", 5)
+		want.Add("b", 3)
+		for range 1 + lag {
+			latest.Tick()
+			want.Tick()
+		}
+		latest.Add("a", 2)
+		want.Add("a", 2)
+
+		states := []*state{
+			{LatestTick: time.Unix(int64(1+lag), 0), Shards: [][]*sliding.Sketch{{latest}}},
+			{LatestTick: time.Unix(1, 0), Shards: [][]*sliding.Sketch{{lagging}}},
+		}
+		if _, rejects := alignedStates(states, []string{"latest", "lagging"}); len(rejects) > 0 {
+			t.Fatalf("lag %d: got rejections %v, want none", lag, rejects)
+		}
+		merged := mergeSketches(newSketch(window), []*sliding.Sketch{latest, lagging}, []int{0, lag})
+		for _, name := range []string{"a", "b"} {
+			item := heap.Item{Item: name, Fingerprint: topk.Fingerprint(name)}
+			for age := range 4 {
+				got := countBetween(merged, item, float64(age), float64(age+1))
+				if want := countBetween(want, item, float64(age), float64(age+1)); got != want {
+					t.Errorf("lag %d: %s at age %d: got %v, want %v", lag, name, age, got, want)
+				}
+			}
+		}
+	}
+
+	// an instance that started a tick later ages its buckets out of phase
+	late := newSketch(window)
+	late.Add("a", 5)
+	latest := newSketch(window)
+	latest.Tick()
+	states := []*state{
+		{LatestTick: time.Unix(1, 0), Shards: [][]*sliding.Sketch{{latest}}},
+		{LatestTick: time.Unix(1, 0), Shards: [][]*sliding.Sketch{{late}}},
+	}
+	aligned, rejects := alignedStates(states, []string{"latest", "late"})
+	if len(aligned) != 1 || len(rejects) != 1 || !strings.HasPrefix(rejects[0], "late: bucket aging out of phase") {
+		t.Errorf("got %d aligned states and rejections %q, want the late one rejected", len(aligned), rejects)
+	}
+}
+
+func TestReceiveSnapshots(t *testing.T) {
+	m := testModel(t, nil)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	m.listener = l
+	type event struct {
+		source string
+		st     *state
+		err    error
+	}
+	events := make(chan event, 10)
+	go m.receiveSnapshots(func(source string, st *state, err error) {
+		events <- event{source, st, err}
+	})
+	next := func() event {
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a snapshot")
+			return event{}
+		}
+	}
+
+	first := testModel(t, nil)
+	second := testModel(t, nil)
+	incompatible := testModel(t, func(c *Config) { c.Width *= 2 })
+	p1 := &statePusher{addr: l.Addr().String()}
+	p2 := &statePusher{addr: l.Addr().String()}
+	p3 := &statePusher{addr: l.Addr().String()}
+
+	for range 2 {
+		if err := first.pushState(p1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e1, e2 := next(), next()
+	if e1.st == nil || e2.st == nil || e1.source != e2.source {
+		t.Fatalf("got %+v and %+v, want two states from the same source", e1, e2)
+	}
+	if err := second.pushState(p2); err != nil {
+		t.Fatal(err)
+	}
+	if e := next(); e.st == nil || e.source == e1.source {
+		t.Errorf("got %+v from a second instance on the same host, want a state from another source (not %s)", e, e1.source)
+	}
+	if err := incompatible.pushState(p3); err != nil {
+		t.Fatal(err)
+	}
+	if e := next(); e.st != nil || e.err == nil || !strings.Contains(e.err.Error(), "width=") {
+		t.Errorf("got %+v from an incompatible instance, want a sketch parameter mismatch", e)
+	}
+
+	p1.conn.Close()
+	if e := next(); e.source != e1.source || e.st != nil || e.err != nil {
+		t.Errorf("got %+v after closing the first connection, want its source without a state", e)
+	}
+	// close the other connections before the configuration is restored, so that they are done checking states
+	p3.conn.Close()
+	p2.conn.Close()
+	if e := next(); e.st != nil || e.err != nil {
+		t.Errorf("got %+v after closing the second connection, want its source without a state", e)
+	}
+}
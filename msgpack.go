@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+func (m *model) readMsgpackItems() {
+	dec := newMsgpackDecoder(bufio.NewReader(m.input))
+	var last time.Time
+	for {
+		v, err := dec.Decode()
+		if err != nil {
+			return
+		}
+		for _, e := range msgpackEvents(v) {
+			timestamp := e.record["timestamp"]
+			if timestamp == nil {
+				timestamp = e.time
+			}
+			value, hasValue := asFloat(e.record[config.Value])
+			last = m.countRecord(record{
+				Item:      asString(e.record["item"]),
+				Count:     asInt(e.record["count"]),
+				Timestamp: timestamp,
+				Distinct:  asString(e.record[config.Distinct]),
+				Value:     value,
+				HasValue:  hasValue,
+			}, last)
+		}
+	}
+}
+
+// msgpackEvent is a record read from a MessagePack value, with the event time of its fluentd entry (if any).
+type msgpackEvent struct {
+	time   any
+	record map[string]any
+}
+
+// msgpackEvents returns the records of a bare map, or of a fluentd forward protocol message in Message mode
+// ([tag, time, record]), Forward mode ([tag, [[time, record], ...]]) or (Compressed)PackedForward mode
+// ([tag, entries, option]), see https://github.com/fluent/fluentd/wiki/Forward-Protocol-Specification-v1
+// Other values have no records.
+func msgpackEvents(v any) []msgpackEvent {
+	switch v := v.(type) {
+	case map[string]any:
+		return []msgpackEvent{{record: v}}
+	case []any:
+		if len(v) < 2 {
+			return nil
+		}
+		if _, ok := v[0].(string); !ok {
+			return nil
+		}
+		switch entries := v[1].(type) {
+		case []any:
+			return fluentEntries(entries)
+		case []byte:
+			return fluentPackedEntries(entries, v[2:])
+		case string:
+			return fluentPackedEntries([]byte(entries), v[2:])
+		}
+		if len(v) >= 3 {
+			if record, ok := v[2].(map[string]any); ok {
+				return []msgpackEvent{{time: v[1], record: record}}
+			}
+		}
+	}
+	return nil
+}
+
+// fluentEntries returns the records of the [time, record] entries of a Forward mode message.
+func fluentEntries(entries []any) []msgpackEvent {
+	var events []msgpackEvent
+	for _, entry := range entries {
+		entry, ok := entry.([]any)
+		if !ok || len(entry) < 2 {
+			continue
+		}
+		if record, ok := entry[1].(map[string]any); ok {
+			events = append(events, msgpackEvent{time: entry[0], record: record})
+		}
+	}
+	return events
+}
+
+// fluentPackedEntries returns the records of the concatenated [time, record] entries of a PackedForward mode message,
+// gunzipping them first if the message's option says they are compressed.
+func fluentPackedEntries(data []byte, option []any) []msgpackEvent {
+	r := io.Reader(bytes.NewReader(data))
+	if len(option) > 0 {
+		if option, ok := option[0].(map[string]any); ok && asString(option["compressed"]) == "gzip" {
+			gz, err := gzip.NewReader(r)
+			if err != nil {
+				return nil
+			}
+			defer gz.Close()
+			r = gz
+		}
+	}
+	dec := newMsgpackDecoder(bufio.NewReader(r))
+	var entries []any
+	for {
+		entry, err := dec.Decode()
+		if err != nil {
+			break
+		}
+		entries = append(entries, entry)
+	}
+	return fluentEntries(entries)
+}
+
+func asString(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// asFloat returns the value of numbers and numeric strings.
+func asFloat(v any) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// asInt returns the value of numbers, clamped to [0, math.MaxInt32].
+func asInt(v any) int {
+	switch v := v.(type) {
+	case int64:
+		return int(max(0, min(v, math.MaxInt32)))
+	case uint64:
+		return int(min(v, math.MaxInt32))
+	case float64:
+		return int(max(0, min(v, math.MaxInt32)))
+	}
+	return 0
+}
+
+const (
+	msgpackExtTimestamp = -1 // https://github.com/msgpack/msgpack/blob/master/spec.md#timestamp-extension-type
+	msgpackExtEventTime = 0  // fluentd EventTime, https://github.com/fluent/fluentd/wiki/Forward-Protocol-Specification-v1#eventtime-ext-format
+)
+
+// msgpackMaxLength is the maximum size of strings, binary data and containers.
+const msgpackMaxLength = 64 << 20
+
+// msgpackMaxDepth is the maximum nesting depth of maps and arrays.
+const msgpackMaxDepth = 100
+
+var errMsgpackInvalid = errors.New("msgpack: invalid data")
+
+// msgpackDecoder decodes a stream of concatenated MessagePack values.
+//
+// Maps decode to map[string]any, arrays to []any, integers to int64 (or uint64 if they don't fit),
+// floats to float64, strings to string, binary data to []byte, and timestamp extensions to time.Time.
+type msgpackDecoder struct {
+	r     *bufio.Reader
+	buf   [8]byte
+	depth int // number of maps and arrays being decoded
+}
+
+func newMsgpackDecoder(r *bufio.Reader) *msgpackDecoder {
+	return &msgpackDecoder{r: r}
+}
+
+func (d *msgpackDecoder) Decode() (any, error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b&0xf0 == 0x80:
+		return d.decodeMap(int(b & 0x0f))
+	case b&0xf0 == 0x90:
+		return d.decodeArray(int(b & 0x0f))
+	case b&0xe0 == 0xa0:
+		return d.readString(int(b & 0x1f))
+	}
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.readLength(1 << (b - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return d.readBytes(n)
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.readLength(1 << (b - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeExt(n)
+	case 0xca:
+		u, err := d.readUint(4)
+		return float64(math.Float32frombits(uint32(u))), err
+	case 0xcb:
+		u, err := d.readUint(8)
+		return math.Float64frombits(u), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, err := d.readUint(1 << (b - 0xcc))
+		if u > math.MaxInt64 {
+			return u, err
+		}
+		return int64(u), err
+	case 0xd0:
+		u, err := d.readUint(1)
+		return int64(int8(u)), err
+	case 0xd1:
+		u, err := d.readUint(2)
+		return int64(int16(u)), err
+	case 0xd2:
+		u, err := d.readUint(4)
+		return int64(int32(u)), err
+	case 0xd3:
+		u, err := d.readUint(8)
+		return int64(u), err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.decodeExt(1 << (b - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.readLength(1 << (b - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.readString(n)
+	case 0xdc, 0xdd:
+		n, err := d.readLength(2 << (b - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeArray(n)
+	case 0xde, 0xdf:
+		n, err := d.readLength(2 << (b - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeMap(n)
+	}
+	return nil, errMsgpackInvalid
+}
+
+func (d *msgpackDecoder) decodeMap(n int) (map[string]any, error) {
+	if d.depth++; d.depth > msgpackMaxDepth {
+		return nil, errMsgpackInvalid
+	}
+	defer func() { d.depth-- }()
+	out := make(map[string]any, min(n, 64))
+	for range n {
+		k, err := d.Decode()
+		if err != nil {
+			return nil, noEOF(err)
+		}
+		v, err := d.Decode()
+		if err != nil {
+			return nil, noEOF(err)
+		}
+		out[asString(k)] = v
+	}
+	return out, nil
+}
+
+func (d *msgpackDecoder) decodeArray(n int) ([]any, error) {
+	if d.depth++; d.depth > msgpackMaxDepth {
+		return nil, errMsgpackInvalid
+	}
+	defer func() { d.depth-- }()
+	out := make([]any, 0, min(n, 64))
+	for range n {
+		v, err := d.Decode()
+		if err != nil {
+			return nil, noEOF(err)
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+func (d *msgpackDecoder) decodeExt(n int) (any, error) {
+	typ, err := d.readUint(1)
+	if err != nil {
+		return nil, err
+	}
+	data, err := d.readBytes(n)
+	if err != nil {
+		return nil, err
+	}
+	switch int8(typ) {
+	case msgpackExtTimestamp:
+		switch n {
+		case 4:
+			return time.Unix(int64(binary.BigEndian.Uint32(data)), 0), nil
+		case 8:
+			u := binary.BigEndian.Uint64(data)
+			return time.Unix(int64(u&(1<<34-1)), int64(u>>34)), nil
+		case 12:
+			nsec := binary.BigEndian.Uint32(data[:4])
+			sec := binary.BigEndian.Uint64(data[4:])
+			return time.Unix(int64(sec), int64(nsec)), nil
+		}
+	case msgpackExtEventTime:
+		if n == 8 {
+			sec := binary.BigEndian.Uint32(data[:4])
+			nsec := binary.BigEndian.Uint32(data[4:])
+			return time.Unix(int64(sec), int64(nsec)), nil
+		}
+	}
+	return data, nil
+}
+
+func (d *msgpackDecoder) readLength(size int) (int, error) {
+	u, err := d.readUint(size)
+	if err == nil && u > msgpackMaxLength {
+		return 0, errMsgpackInvalid
+	}
+	return int(u), err
+}
+
+func (d *msgpackDecoder) readUint(size int) (uint64, error) {
+	buf := d.buf[:size]
+	if _, err := io.ReadFull(d.r, buf); err != nil {
+		return 0, noEOF(err)
+	}
+	var u uint64
+	for _, b := range buf {
+		u = u<<8 | uint64(b)
+	}
+	return u, nil
+}
+
+func (d *msgpackDecoder) readBytes(n int) ([]byte, error) {
+	out := make([]byte, n)
+	if _, err := io.ReadFull(d.r, out); err != nil {
+		return nil, noEOF(err)
+	}
+	return out, nil
+}
+
+func (d *msgpackDecoder) readString(n int) (string, error) {
+	b, err := d.readBytes(n)
+	return string(b), err
+}
+
+// noEOF turns an EOF in the middle of a value into an io.ErrUnexpectedEOF.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
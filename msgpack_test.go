@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func decodeMsgpack(t *testing.T, data []byte) []any {
+	t.Helper()
+	dec := newMsgpackDecoder(bufio.NewReader(bytes.NewReader(data)))
+	var values []any
+	for {
+		v, err := dec.Decode()
+		if err == io.EOF {
+			return values
+		}
+		if err != nil {
+			t.Fatalf("decode % x: %v", data, err)
+		}
+		values = append(values, v)
+	}
+}
+
+func TestMsgpackDecoder(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want any
+	}{
+		{"positive fixint", []byte{0x7f}, int64(127)},
+		{"negative fixint", []byte{0xe0}, int64(-32)},
+		{"uint64 above int64", []byte{0xcf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, uint64(1<<64 - 1)},
+		{"int16", []byte{0xd1, 0xff, 0x00}, int64(-256)},
+		{"float32", []byte{0xca, 0x3f, 0xc0, 0x00, 0x00}, float64(1.5)},
+		{"fixstr", []byte{0xa3, 'a', 'b', 'c'}, "abc"},
+		{"str8", []byte{0xd9, 0x02, 'h', 'i'}, "hi"},
+		{"bin8", []byte{0xc4, 0x02, 0x01, 0x02}, []byte{0x01, 0x02}},
+		{"nil", []byte{0xc0}, nil},
+		{
+			"fixext4 timestamp32",
+			[]byte{0xd6, 0xff, 0x66, 0xf1, 0x5f, 0x40},
+			time.Unix(0x66f15f40, 0),
+		},
+		{
+			"fixext8 timestamp64",
+			// nanoseconds 500 in the upper 30 bits, seconds 0x66f15f40 in the lower 34 bits
+			[]byte{0xd7, 0xff, 0x00, 0x00, 0x07, 0xd0, 0x66, 0xf1, 0x5f, 0x40},
+			time.Unix(0x66f15f40, 500),
+		},
+		{
+			"ext8 timestamp96",
+			[]byte{0xc7, 0x0c, 0xff, 0x00, 0x00, 0x00, 0x07, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			time.Unix(-1, 7),
+		},
+		{
+			"fluentd EventTime",
+			[]byte{0xd7, 0x00, 0x66, 0xf1, 0x5f, 0x40, 0x00, 0x00, 0x00, 0x2a},
+			time.Unix(0x66f15f40, 42),
+		},
+		{"unknown ext", []byte{0xd4, 0x05, 0x01}, []byte{0x01}},
+		{"fixarray", []byte{0x92, 0x01, 0xa1, 'x'}, []any{int64(1), "x"}},
+		{"array16", []byte{0xdc, 0x00, 0x02, 0x01, 0x02}, []any{int64(1), int64(2)}},
+		{"array32", []byte{0xdd, 0x00, 0x00, 0x00, 0x01, 0xc3}, []any{true}},
+		{"fixmap", []byte{0x81, 0xa4, 'i', 't', 'e', 'm', 0xa1, 'a'}, map[string]any{"item": "a"}},
+		{"map16", []byte{0xde, 0x00, 0x01, 0xa1, 'k', 0x05}, map[string]any{"k": int64(5)}},
+		{"map32", []byte{0xdf, 0x00, 0x00, 0x00, 0x01, 0xa1, 'k', 0xc2}, map[string]any{"k": false}},
+		{"map with integer key", []byte{0x81, 0x01, 0x02}, map[string]any{"1": int64(2)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := decodeMsgpack(t, tt.data)
+			if len(values) != 1 {
+				t.Fatalf("got %d values, want 1", len(values))
+			}
+			if !reflect.DeepEqual(values[0], tt.want) {
+				t.Errorf("got %#v, want %#v", values[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestMsgpackDecoderInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"reserved type", []byte{0xc1}, errMsgpackInvalid},
+		{"truncated string", []byte{0xa3, 'a'}, io.ErrUnexpectedEOF},
+		{"truncated array16 length", []byte{0xdc, 0x00}, io.ErrUnexpectedEOF},
+		{"truncated map element", []byte{0x81, 0xa1, 'k'}, io.ErrUnexpectedEOF},
+		{"huge map32 length", []byte{0xdf, 0xff, 0xff, 0xff, 0xff}, errMsgpackInvalid},
+		{"huge bin32 length", []byte{0xc6, 0x7f, 0xff, 0xff, 0xff}, errMsgpackInvalid},
+		{"deeply nested arrays", bytes.Repeat([]byte{0x91}, 1<<20), errMsgpackInvalid},
+		{"deeply nested maps", bytes.Repeat([]byte{0x81, 0xa1, 'k'}, 1<<20), errMsgpackInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dec := newMsgpackDecoder(bufio.NewReader(bytes.NewReader(tt.data)))
+			if _, err := dec.Decode(); err != tt.want {
+				t.Errorf("got error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMsgpackEvents(t *testing.T) {
+	record := []byte{0x82, 0xa4, 'i', 't', 'e', 'm', 0xa1, 'a', 0xa5, 'c', 'o', 'u', 'n', 't', 0x03}
+	eventTime := []byte{0xd7, 0x00, 0x66, 0xf1, 0x5f, 0x40, 0x00, 0x00, 0x00, 0x00}
+	entry := concat([]byte{0x92}, eventTime, record)
+	entries := concat(entry, []byte{0x92, 0x01}, record)
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write(entries)
+	gz.Close()
+	tag := []byte{0xa3, 'a', 'p', 'p'}
+	want := map[string]any{"item": "a", "count": int64(3)}
+
+	tests := []struct {
+		name string
+		data []byte
+		want []msgpackEvent
+	}{
+		{"bare map", record, []msgpackEvent{{record: want}}},
+		{
+			"message mode",
+			concat([]byte{0x93}, tag, eventTime, record),
+			[]msgpackEvent{{time: time.Unix(0x66f15f40, 0), record: want}},
+		},
+		{
+			"message mode with option",
+			concat([]byte{0x94}, tag, []byte{0x01}, record, []byte{0x80}),
+			[]msgpackEvent{{time: int64(1), record: want}},
+		},
+		{
+			"forward mode",
+			concat([]byte{0x92}, tag, []byte{0x92}, entry, []byte{0x92, 0x01}, record),
+			[]msgpackEvent{{time: time.Unix(0x66f15f40, 0), record: want}, {time: int64(1), record: want}},
+		},
+		{
+			"packed forward mode",
+			concat([]byte{0x92}, tag, []byte{0xc4, byte(len(entries))}, entries),
+			[]msgpackEvent{{time: time.Unix(0x66f15f40, 0), record: want}, {time: int64(1), record: want}},
+		},
+		{
+			"compressed packed forward mode",
+			concat([]byte{0x93}, tag, []byte{0xc4, byte(gzipped.Len())}, gzipped.Bytes(),
+				[]byte{0x81, 0xaa}, []byte("compressed"), []byte{0xa4}, []byte("gzip")),
+			[]msgpackEvent{{time: time.Unix(0x66f15f40, 0), record: want}, {time: int64(1), record: want}},
+		},
+		{"array without tag", concat([]byte{0x92, 0x01}, record), nil},
+		{"forward entry without record", concat([]byte{0x92}, tag, []byte{0x91, 0x92, 0x01, 0x02}), nil},
+		{"integer", []byte{0x01}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := decodeMsgpack(t, tt.data)
+			if len(values) != 1 {
+				t.Fatalf("got %d values, want 1", len(values))
+			}
+			if got := msgpackEvents(values[0]); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAsInt(t *testing.T) {
+	tests := []struct {
+		value any
+		want  int
+	}{
+		{int64(5), 5},
+		{int64(-5), 0},
+		{uint64(1 << 40), 1<<31 - 1},
+		{float64(2.7), 2},
+		{float64(-3), 0},
+		{"5", 0},
+		{nil, 0},
+	}
+	for _, tt := range tests {
+		if got := asInt(tt.value); got != tt.want {
+			t.Errorf("asInt(%#v) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"hash/maphash"
+	"math"
+	"time"
+
+	"github.com/keilerkonzept/topk"
+	"github.com/keilerkonzept/topk/heap"
+)
+
+// newItemsFalsePositiveRate is the false positive rate of the Bloom filters of known items at -new-capacity items
+// per window, i.e. the probability of missing a new item.
+const newItemsFalsePositiveRate = 0.01
+
+// bloomFilter is a Bloom filter over item hashes, using double hashing.
+type bloomFilter struct {
+	bits   []uint64
+	hashes int
+}
+
+func newBloomFilter(capacity int) bloomFilter {
+	bits := math.Ceil(-float64(capacity) * math.Log(newItemsFalsePositiveRate) / (math.Ln2 * math.Ln2))
+	hashes := max(1, int(math.Round(bits/float64(capacity)*math.Ln2)))
+	return bloomFilter{bits: make([]uint64, (int(bits)+63)/64), hashes: hashes}
+}
+
+func (f bloomFilter) add(hash uint64) {
+	n := uint64(len(f.bits) * 64)
+	h1, h2 := hash, hash>>32|1
+	for i := range uint64(f.hashes) {
+		bit := (h1 + i*h2) % n
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (f bloomFilter) contains(hash uint64) bool {
+	n := uint64(len(f.bits) * 64)
+	h1, h2 := hash, hash>>32|1
+	for i := range uint64(f.hashes) {
+		bit := (h1 + i*h2) % n
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+var newItemsSeed = maphash.MakeSeed()
+
+// newItemDetector recognizes a shard's items that were not seen in the last -new-windows (longest) windows.
+// Items are remembered in a time-decayed Bloom filter: one filter per window-long generation, of which the oldest
+// is dropped every window. Until the filters cover -new-windows windows, no items are considered new.
+type newItemDetector struct {
+	generations []bloomFilter  // latest first
+	windowTicks int            // ticks per generation
+	ticks       int            // ticks since the latest generation started
+	learning    int            // ticks until the filters cover -new-windows windows
+	fresh       map[string]int // new items first seen in the last window, with the ticks since then
+	limit       int            // maximum number of fresh items
+	untracked   int            // new items of the last window that were not tracked because fresh was full
+}
+
+func newNewItemDetector(windowTicks, capacity int) *newItemDetector {
+	d := &newItemDetector{
+		windowTicks: windowTicks,
+		learning:    config.NewWindows * windowTicks,
+		fresh:       make(map[string]int),
+		limit:       capacity,
+	}
+	for range config.NewWindows + 1 {
+		d.generations = append(d.generations, newBloomFilter(capacity))
+	}
+	return d
+}
+
+// newItemsCapacity returns the -new-capacity of each shard.
+func newItemsCapacity() int {
+	return max(1, (config.NewCapacity+config.Shards-1)/config.Shards)
+}
+
+// Add remembers the item, and tracks it as fresh if it was not seen before.
+func (d *newItemDetector) Add(item string) {
+	hash := maphash.String(newItemsSeed, item)
+	if d.learning == 0 {
+		if _, ok := d.fresh[item]; !ok && !d.known(hash) {
+			if len(d.fresh) < d.limit {
+				d.fresh[item] = 0
+			} else {
+				d.untracked++
+			}
+		}
+	}
+	d.generations[0].add(hash)
+}
+
+func (d *newItemDetector) known(hash uint64) bool {
+	for _, f := range d.generations {
+		if f.contains(hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// Ticks ages the fresh items, and drops the oldest generation for each window that passed.
+func (d *newItemDetector) Ticks(n int) {
+	d.learning = max(0, d.learning-n)
+	for item, age := range d.fresh {
+		if age+n >= d.windowTicks {
+			delete(d.fresh, item)
+		} else {
+			d.fresh[item] = age + n
+		}
+	}
+	d.ticks += n
+	rotations := d.ticks / d.windowTicks
+	d.ticks %= d.windowTicks
+	if rotations > 0 {
+		d.untracked = 0
+	}
+	for range min(rotations, len(d.generations)) {
+		oldest := d.generations[len(d.generations)-1]
+		clear(oldest.bits)
+		copy(d.generations[1:], d.generations)
+		d.generations[0] = oldest
+	}
+}
+
+// IsNew returns whether the item is new, and the number of ticks since it was first seen.
+func (d *newItemDetector) IsNew(item string) (int, bool) {
+	age, ok := d.fresh[item]
+	return age, ok
+}
+
+// newItems returns which of the items are new, and the ticks since they were first seen, or nil without -new-windows.
+func (m *model) newItems(items []heap.Item) ([]bool, []int) {
+	if config.NewWindows <= 0 {
+		return nil, nil
+	}
+	isNew := make([]bool, len(items))
+	ages := make([]int, len(items))
+	for i, item := range items {
+		sh := m.shards[shardIndex(item.Item, len(m.shards))]
+		sh.mu.Lock()
+		ages[i], isNew[i] = sh.newItems.IsNew(item.Item)
+		sh.mu.Unlock()
+	}
+	return isNew, ages
+}
+
+// newItemList returns the -k new items with the largest counts in the given window, for the new items view.
+// Like the other views, it is capped at k items; the status line counts all of them.
+func (m *model) newItemList(window int) []heap.Item {
+	var items []heap.Item
+	for _, sh := range m.shards {
+		sh.mu.Lock()
+		for item := range sh.newItems.fresh {
+			items = append(items, heap.Item{Item: item, Count: sh.count(window, item), Fingerprint: topk.Fingerprint(item)})
+		}
+		sh.mu.Unlock()
+	}
+	return topItems(items, config.K)
+}
+
+// newItemsCounts returns the number of new items (and of untracked new items) in the last window,
+// and the ticks until new items are detected.
+func (m *model) newItemsCounts() (fresh, untracked, learning int) {
+	for _, sh := range m.shards {
+		sh.mu.Lock()
+		fresh += len(sh.newItems.fresh)
+		untracked += sh.newItems.untracked
+		learning = max(learning, sh.newItems.learning)
+		sh.mu.Unlock()
+	}
+	return fresh, untracked, learning
+}
+
+func (m *model) newItemsStatus() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.newLearning > 0 {
+		return fmt.Sprintf("new items learning (%s left)", formatDuration(time.Duration(m.newLearning)*config.TickSize))
+	}
+	status := fmt.Sprintf("new items %s", formatSI(float64(m.newFresh)))
+	if m.newUntracked > 0 {
+		status += fmt.Sprintf(" (%s untracked)", formatSI(float64(m.newUntracked)))
+	}
+	return status
+}
+
+// formatFirstSeen formats the time since a new item was first seen, e.g. first seen 12s ago.
+func formatFirstSeen(ticks int) string {
+	return "first seen " + formatDuration(time.Duration(ticks)*config.TickSize) + " ago"
+}
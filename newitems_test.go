@@ -0,0 +1,96 @@
+package main
+
+import (
+	"hash/maphash"
+	"reflect"
+	"testing"
+)
+
+func TestNewItemDetector(t *testing.T) {
+	testModel(t, func(c *Config) { c.NewWindows = 2 })
+	d := newNewItemDetector(2, 100)
+	isNew := func(item string) bool {
+		_, ok := d.IsNew(item)
+		return ok
+	}
+	known := func(item string) bool { return d.known(maphash.String(newItemsSeed, item)) }
+
+	d.Add("a")
+	d.Ticks(1)
+	d.Add("b")
+	if isNew("b") {
+		t.Error("b is new while learning")
+	}
+	d.Ticks(3) // the filters cover 2 windows
+	if d.learning != 0 {
+		t.Fatalf("still learning for %d ticks after 2 windows", d.learning)
+	}
+	d.Add("a")
+	d.Add("c")
+	if isNew("a") || !isNew("c") {
+		t.Errorf("got new a=%v c=%v, want only c", isNew("a"), isNew("c"))
+	}
+	d.Ticks(1)
+	if age, ok := d.IsNew("c"); !ok || age != 1 {
+		t.Errorf("got c new=%v at age %d after 1 tick, want new at age 1", ok, age)
+	}
+	d.Ticks(1) // c was first seen a window ago
+	if isNew("c") {
+		t.Error("c is still new after a window")
+	}
+
+	// a and c were last seen in the generation that is now one window old, and are dropped with it 2 windows later
+	d.Ticks(2)
+	if !known("a") || !known("c") {
+		t.Errorf("got known a=%v c=%v after 2 windows, want both known", known("a"), known("c"))
+	}
+	d.Ticks(2)
+	if known("a") || known("c") {
+		t.Errorf("got known a=%v c=%v after 3 windows, want neither known", known("a"), known("c"))
+	}
+	d.Add("a")
+	if !isNew("a") {
+		t.Error("a is not new again after 3 windows")
+	}
+}
+
+func TestNewItemDetectorLimit(t *testing.T) {
+	testModel(t, func(c *Config) { c.NewWindows = 1 })
+	d := newNewItemDetector(2, 1)
+	d.learning = 0
+	d.Add("a")
+	d.Add("b")
+	d.Add("a")
+	if len(d.fresh) != 1 || d.untracked != 1 {
+		t.Errorf("got fresh %v and %d untracked, want one of each", d.fresh, d.untracked)
+	}
+	d.Ticks(2)
+	if len(d.fresh) != 0 || d.untracked != 0 {
+		t.Errorf("got fresh %v and %d untracked after a window, want none", d.fresh, d.untracked)
+	}
+}
+
+func TestNewItemList(t *testing.T) {
+	m := testModel(t, func(c *Config) {
+		c.NewWindows = 1
+		c.K = 2
+	})
+	for _, sh := range m.shards {
+		sh.newItems.learning = 0
+	}
+	m.add(record{Item: "a", Count: 3})
+	m.add(record{Item: "b", Count: 1})
+	m.add(record{Item: "c", Count: 2})
+	flushQueues(m)
+
+	var got []string
+	for _, item := range m.newItemList(0) {
+		got = append(got, item.Item)
+	}
+	if want := []string{"a", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got new items %v, want the top %d: %v", got, config.K, want)
+	}
+	if fresh, _, _ := m.newItemsCounts(); fresh != 3 {
+		t.Errorf("counted %d new items, want all 3", fresh)
+	}
+}
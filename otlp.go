@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	otlpLogsPath = "/v1/logs"
+
+	otlpTimeEvent    = "event"
+	otlpTimeObserved = "observed"
+)
+
+// otlpMaxBodySize is the maximum size of a (decompressed) request body.
+const otlpMaxBodySize = 32 << 20
+
+// serveOTLPLogs accepts OTLP/HTTP log export requests (JSON encoding) on the listener.
+func (m *model) serveOTLPLogs() {
+	http.Serve(m.listener, m.otlpLogsHandler())
+}
+
+// otlpLogsHandler counts the log records of OTLP/HTTP log export requests (JSON encoding).
+func (m *model) otlpLogsHandler() http.Handler {
+	var (
+		mu   sync.Mutex
+		last time.Time
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+otlpLogsPath, func(w http.ResponseWriter, r *http.Request) {
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+			http.Error(w, "only the JSON encoding (application/json) is supported", http.StatusUnsupportedMediaType)
+			return
+		}
+		body := io.Reader(http.MaxBytesReader(w, r.Body, otlpMaxBodySize))
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			defer gz.Close()
+			body = io.LimitReader(gz, otlpMaxBodySize)
+		}
+		var req otlpExportLogsRequest
+		if err := json.NewDecoder(body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		for _, rl := range req.ResourceLogs {
+			for _, sl := range rl.ScopeLogs {
+				for _, lr := range sl.LogRecords {
+					item, ok := lr.Attributes.get(config.OTLPAttribute)
+					if !ok {
+						item, ok = rl.Resource.Attributes.get(config.OTLPAttribute)
+					}
+					if !ok {
+						continue
+					}
+					var distinct, value string
+					if config.Distinct != "" {
+						if distinct, ok = lr.Attributes.get(config.Distinct); !ok {
+							distinct, _ = rl.Resource.Attributes.get(config.Distinct)
+						}
+					}
+					if config.Value != "" {
+						if value, ok = lr.Attributes.get(config.Value); !ok {
+							value, _ = rl.Resource.Attributes.get(config.Value)
+						}
+					}
+					v, err := strconv.ParseFloat(value, 64)
+					last = m.countRecord(record{
+						Item:      item,
+						Count:     1,
+						Timestamp: lr.time(config.OTLPTime),
+						Distinct:  distinct,
+						Value:     v,
+						HasValue:  err == nil,
+					}, last)
+				}
+			}
+		}
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+	})
+	return mux
+}
+
+// otlpExportLogsRequest is the JSON encoding of an OTLP ExportLogsServiceRequest, reduced to the fields we use,
+// see https://github.com/open-telemetry/opentelemetry-proto/blob/main/opentelemetry/proto/logs/v1/logs.proto
+type otlpExportLogsRequest struct {
+	ResourceLogs []struct {
+		Resource struct {
+			Attributes otlpAttributes `json:"attributes"`
+		} `json:"resource"`
+		ScopeLogs []struct {
+			LogRecords []otlpLogRecord `json:"logRecords"`
+		} `json:"scopeLogs"`
+	} `json:"resourceLogs"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano         otlpUint64     `json:"timeUnixNano"`
+	ObservedTimeUnixNano otlpUint64     `json:"observedTimeUnixNano"`
+	Attributes           otlpAttributes `json:"attributes"`
+}
+
+// time returns the event or observed timestamp (falling back to the other one if unset), or nil if neither is set.
+func (r otlpLogRecord) time(which string) any {
+	ts := []otlpUint64{r.TimeUnixNano, r.ObservedTimeUnixNano}
+	if which == otlpTimeObserved {
+		ts[0], ts[1] = ts[1], ts[0]
+	}
+	for _, t := range ts {
+		if t != 0 {
+			return time.Unix(0, int64(t))
+		}
+	}
+	return nil
+}
+
+type otlpAttributes []struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+func (a otlpAttributes) get(key string) (string, bool) {
+	for _, kv := range a {
+		if kv.Key == key {
+			return kv.Value.String(), true
+		}
+	}
+	return "", false
+}
+
+// otlpValue is the JSON encoding of an OTLP AnyValue.
+type otlpValue struct {
+	StringValue *string          `json:"stringValue"`
+	BoolValue   *bool            `json:"boolValue"`
+	IntValue    *otlpUint64      `json:"intValue"`
+	DoubleValue *float64         `json:"doubleValue"`
+	BytesValue  []byte           `json:"bytesValue"`
+	ArrayValue  *json.RawMessage `json:"arrayValue"`
+	KvlistValue *json.RawMessage `json:"kvlistValue"`
+}
+
+func (v otlpValue) String() string {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return strconv.FormatBool(*v.BoolValue)
+	case v.IntValue != nil:
+		return strconv.FormatInt(int64(*v.IntValue), 10)
+	case v.DoubleValue != nil:
+		return strconv.FormatFloat(*v.DoubleValue, 'g', -1, 64)
+	case v.BytesValue != nil:
+		return string(v.BytesValue)
+	case v.ArrayValue != nil:
+		return string(*v.ArrayValue)
+	case v.KvlistValue != nil:
+		return string(*v.KvlistValue)
+	}
+	return ""
+}
+
+// otlpUint64 is a 64-bit integer, encoded either as a JSON number or as a decimal string.
+type otlpUint64 uint64
+
+func (u *otlpUint64) UnmarshalJSON(data []byte) error {
+	data = bytes.Trim(data, `"`)
+	if len(data) == 0 || string(data) == "null" {
+		return nil
+	}
+	if n, err := strconv.ParseUint(string(data), 10, 64); err == nil {
+		*u = otlpUint64(n)
+		return nil
+	}
+	n, err := strconv.ParseInt(string(data), 10, 64)
+	*u = otlpUint64(n)
+	return err
+}
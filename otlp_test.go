@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const otlpTestRequest = `{
+  "resourceLogs": [
+    {
+      "resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "checkout"}}]},
+      "scopeLogs": [{"logRecords": [
+        {"timeUnixNano": "1700000000000000000", "observedTimeUnixNano": "1700000005000000000"},
+        {"observedTimeUnixNano": 1700000003000000000, "attributes": [{"key": "service.name", "value": {"stringValue": "cart"}}]}
+      ]}]
+    },
+    {
+      "resource": {"attributes": [{"key": "host.name", "value": {"stringValue": "a"}}]},
+      "scopeLogs": [{"logRecords": [
+        {"attributes": [{"key": "service.name", "value": {"intValue": "42"}}]},
+        {"attributes": [{"key": "other", "value": {"stringValue": "x"}}]}
+      ]}]
+    }
+  ]
+}`
+
+func postOTLP(t *testing.T, url, contentType, contentEncoding string, body []byte) int {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url+otlpLogsPath, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if contentEncoding != "" {
+		req.Header.Set("Content-Encoding", contentEncoding)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestOTLPLogsHandler(t *testing.T) {
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write([]byte(otlpTestRequest))
+	gz.Close()
+
+	tests := []struct {
+		name            string
+		time            string
+		contentType     string
+		contentEncoding string
+		body            []byte
+		status          int
+		counts          map[string]uint32
+		latestTick      time.Time
+	}{
+		{
+			name:        "event time",
+			time:        otlpTimeEvent,
+			contentType: "application/json",
+			body:        []byte(otlpTestRequest),
+			status:      http.StatusOK,
+			counts:      map[string]uint32{"checkout": 1, "cart": 1, "42": 1},
+			latestTick:  time.Unix(1700000003, 0), // the second record only has an observed time
+		},
+		{
+			name:        "observed time",
+			time:        otlpTimeObserved,
+			contentType: "application/json; charset=utf-8",
+			body:        []byte(otlpTestRequest),
+			status:      http.StatusOK,
+			counts:      map[string]uint32{"checkout": 1, "cart": 1, "42": 1},
+			latestTick:  time.Unix(1700000005, 0),
+		},
+		{
+			name:            "gzip",
+			time:            otlpTimeEvent,
+			contentType:     "application/json",
+			contentEncoding: "gzip",
+			body:            gzipped.Bytes(),
+			status:          http.StatusOK,
+			counts:          map[string]uint32{"checkout": 1, "cart": 1, "42": 1},
+			latestTick:      time.Unix(1700000003, 0),
+		},
+		{
+			name:        "protobuf",
+			time:        otlpTimeEvent,
+			contentType: "application/x-protobuf",
+			body:        []byte{0x0a, 0x00},
+			status:      http.StatusUnsupportedMediaType,
+			counts:      map[string]uint32{},
+		},
+		{
+			name:            "invalid gzip",
+			time:            otlpTimeEvent,
+			contentType:     "application/json",
+			contentEncoding: "gzip",
+			body:            []byte(otlpTestRequest),
+			status:          http.StatusBadRequest,
+			counts:          map[string]uint32{},
+		},
+		{
+			name:        "invalid JSON",
+			time:        otlpTimeEvent,
+			contentType: "application/json",
+			body:        []byte(`{"resourceLogs": [`),
+			status:      http.StatusBadRequest,
+			counts:      map[string]uint32{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testModel(t, func(c *Config) {
+				c.Format = formatOTLP
+				c.OTLPTime = tt.time
+			})
+			srv := httptest.NewServer(m.otlpLogsHandler())
+			defer srv.Close()
+			if status := postOTLP(t, srv.URL, tt.contentType, tt.contentEncoding, tt.body); status != tt.status {
+				t.Errorf("got status %d, want %d", status, tt.status)
+			}
+			if counts := queuedCounts(m); !reflect.DeepEqual(counts, tt.counts) {
+				t.Errorf("got counts %v, want %v", counts, tt.counts)
+			}
+			if !m.latestTick.Equal(tt.latestTick) {
+				t.Errorf("got latest tick %v, want %v", m.latestTick, tt.latestTick)
+			}
+		})
+	}
+}
+
+func TestOTLPLogRecordTime(t *testing.T) {
+	event, observed := time.Unix(1700000000, 1), time.Unix(1700000005, 2)
+	tests := []struct {
+		record otlpLogRecord
+		which  string
+		want   any
+	}{
+		{otlpLogRecord{TimeUnixNano: 1700000000_000000001, ObservedTimeUnixNano: 1700000005_000000002}, otlpTimeEvent, event},
+		{otlpLogRecord{TimeUnixNano: 1700000000_000000001, ObservedTimeUnixNano: 1700000005_000000002}, otlpTimeObserved, observed},
+		{otlpLogRecord{ObservedTimeUnixNano: 1700000005_000000002}, otlpTimeEvent, observed},
+		{otlpLogRecord{TimeUnixNano: 1700000000_000000001}, otlpTimeObserved, event},
+		{otlpLogRecord{}, otlpTimeEvent, nil},
+	}
+	for _, tt := range tests {
+		if got := tt.record.time(tt.which); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%+v.time(%q) = %v, want %v", tt.record, tt.which, got, tt.want)
+		}
+	}
+}
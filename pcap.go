@@ -0,0 +1,478 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"net/netip"
+	"time"
+)
+
+const (
+	pcapKeySrc     = "src"
+	pcapKeyDst     = "dst"
+	pcapKeyFlow    = "flow"
+	pcapKeyDstPort = "dport"
+
+	pcapCountPackets = "packets"
+	pcapCountBytes   = "bytes"
+)
+
+func (m *model) readPcapItems() {
+	r, err := newPcapReader(bufio.NewReader(m.input))
+	if err != nil {
+		return
+	}
+	var last time.Time
+	for {
+		p, err := r.Next()
+		if err != nil {
+			return
+		}
+		item, ok := p.key(config.PcapKey)
+		if !ok {
+			continue
+		}
+		count := 1
+		if config.PcapCount == pcapCountBytes {
+			count = p.Length
+		}
+		var distinct string
+		if config.Distinct != "" {
+			distinct, _ = p.key(config.Distinct)
+		}
+		last = m.countRecord(record{
+			Item:      item,
+			Count:     count,
+			Timestamp: p.Time,
+			Distinct:  distinct,
+		}, last)
+	}
+}
+
+// Link-layer header types, see https://www.tcpdump.org/linktypes.html
+const (
+	linkTypeNull     = 0
+	linkTypeEthernet = 1
+	linkTypeRaw      = 101
+	linkTypeLoop     = 108
+	linkTypeLinuxSLL = 113
+	linkTypeIPv4     = 228
+	linkTypeIPv6     = 229
+	linkTypeLinuxSL2 = 276
+)
+
+const (
+	etherTypeIPv4  = 0x0800
+	etherTypeIPv6  = 0x86dd
+	etherTypeVLAN  = 0x8100
+	etherTypeQinQ  = 0x88a8
+	etherTypeQinQ1 = 0x9100
+)
+
+var errPcapInvalid = errors.New("pcap: invalid data")
+
+// pcapMaxPacketSize is the maximum size of a packet record.
+const pcapMaxPacketSize = 1 << 20
+
+// pcapPacket is a captured packet.
+type pcapPacket struct {
+	Time     time.Time
+	LinkType uint16
+	Data     []byte // Captured bytes, possibly truncated.
+	Length   int    // Original length on the wire.
+}
+
+// pcapReader reads packets from classic pcap and pcapng files.
+type pcapReader struct {
+	r     *bufio.Reader
+	order binary.ByteOrder
+
+	// classic pcap
+	linkType uint16
+	nanos    bool
+
+	// pcapng
+	ng         bool
+	interfaces []pcapngInterface
+}
+
+type pcapngInterface struct {
+	linkType uint16
+	tsResol  uint8
+}
+
+const (
+	pcapMagicMicros = 0xa1b2c3d4
+	pcapMagicNanos  = 0xa1b23c4d
+
+	pcapngBlockSHB       = 0x0a0d0d0a
+	pcapngBlockIDB       = 0x00000001
+	pcapngBlockPacket    = 0x00000002
+	pcapngBlockSPB       = 0x00000003
+	pcapngBlockEPB       = 0x00000006
+	pcapngByteOrderMagic = 0x1a2b3c4d
+	pcapngOptionTsResol  = 9
+)
+
+func newPcapReader(r *bufio.Reader) (*pcapReader, error) {
+	magic, err := r.Peek(4)
+	if err != nil {
+		return nil, err
+	}
+	p := &pcapReader{r: r}
+	if binary.BigEndian.Uint32(magic) == pcapngBlockSHB {
+		p.ng = true
+		return p, nil
+	}
+
+	var header [24]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch order.Uint32(header[:4]) {
+		case pcapMagicMicros:
+			p.order = order
+		case pcapMagicNanos:
+			p.order, p.nanos = order, true
+		default:
+			continue
+		}
+		p.linkType = uint16(p.order.Uint32(header[20:]))
+		return p, nil
+	}
+	return nil, errPcapInvalid
+}
+
+// Next returns the next packet.
+func (p *pcapReader) Next() (pcapPacket, error) {
+	if p.ng {
+		return p.nextNG()
+	}
+	var header [16]byte
+	if _, err := io.ReadFull(p.r, header[:]); err != nil {
+		return pcapPacket{}, err
+	}
+	sec := p.order.Uint32(header[0:])
+	frac := p.order.Uint32(header[4:])
+	capLen := p.order.Uint32(header[8:])
+	origLen := p.order.Uint32(header[12:])
+	if capLen > pcapMaxPacketSize {
+		return pcapPacket{}, errPcapInvalid
+	}
+	data := make([]byte, capLen)
+	if _, err := io.ReadFull(p.r, data); err != nil {
+		return pcapPacket{}, noEOF(err)
+	}
+	nsec := int64(frac) * 1000
+	if p.nanos {
+		nsec = int64(frac)
+	}
+	return pcapPacket{
+		Time:     time.Unix(int64(sec), nsec),
+		LinkType: p.linkType,
+		Data:     data,
+		Length:   int(origLen),
+	}, nil
+}
+
+func (p *pcapReader) nextNG() (pcapPacket, error) {
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(p.r, header[:]); err != nil {
+			return pcapPacket{}, err
+		}
+		if binary.BigEndian.Uint32(header[:4]) == pcapngBlockSHB {
+			bom, err := p.r.Peek(4)
+			if err != nil {
+				return pcapPacket{}, noEOF(err)
+			}
+			switch {
+			case binary.LittleEndian.Uint32(bom) == pcapngByteOrderMagic:
+				p.order = binary.LittleEndian
+			case binary.BigEndian.Uint32(bom) == pcapngByteOrderMagic:
+				p.order = binary.BigEndian
+			default:
+				return pcapPacket{}, errPcapInvalid
+			}
+			p.interfaces = p.interfaces[:0]
+		}
+		if p.order == nil {
+			return pcapPacket{}, errPcapInvalid
+		}
+		blockType := p.order.Uint32(header[:4])
+		blockLen := p.order.Uint32(header[4:])
+		if blockLen < 12 || blockLen%4 != 0 || blockLen > pcapMaxPacketSize {
+			return pcapPacket{}, errPcapInvalid
+		}
+		body := make([]byte, blockLen-8)
+		if _, err := io.ReadFull(p.r, body); err != nil {
+			return pcapPacket{}, noEOF(err)
+		}
+		body = body[:len(body)-4] // trailing block length
+
+		switch blockType {
+		case pcapngBlockIDB:
+			if len(body) < 8 {
+				return pcapPacket{}, errPcapInvalid
+			}
+			iface := pcapngInterface{
+				linkType: p.order.Uint16(body),
+				tsResol:  6,
+			}
+			p.walkOptions(body[8:], func(code uint16, value []byte) {
+				if code == pcapngOptionTsResol && len(value) == 1 {
+					iface.tsResol = value[0]
+				}
+			})
+			p.interfaces = append(p.interfaces, iface)
+
+		case pcapngBlockEPB, pcapngBlockPacket:
+			if len(body) < 20 {
+				return pcapPacket{}, errPcapInvalid
+			}
+			id := p.order.Uint32(body)
+			if blockType == pcapngBlockPacket {
+				id = uint32(p.order.Uint16(body))
+			}
+			if int(id) >= len(p.interfaces) {
+				return pcapPacket{}, errPcapInvalid
+			}
+			iface := p.interfaces[id]
+			ts := uint64(p.order.Uint32(body[4:]))<<32 | uint64(p.order.Uint32(body[8:]))
+			capLen := p.order.Uint32(body[12:])
+			origLen := p.order.Uint32(body[16:])
+			if uint64(capLen) > uint64(len(body)-20) {
+				return pcapPacket{}, errPcapInvalid
+			}
+			return pcapPacket{
+				Time:     iface.time(ts),
+				LinkType: iface.linkType,
+				Data:     body[20 : 20+capLen],
+				Length:   int(origLen),
+			}, nil
+
+		case pcapngBlockSPB:
+			if len(body) < 4 || len(p.interfaces) == 0 {
+				return pcapPacket{}, errPcapInvalid
+			}
+			origLen := p.order.Uint32(body)
+			data := body[4:]
+			if uint64(origLen) < uint64(len(data)) {
+				data = data[:origLen]
+			}
+			return pcapPacket{
+				LinkType: p.interfaces[0].linkType,
+				Data:     data,
+				Length:   int(origLen),
+			}, nil
+		}
+	}
+}
+
+func (p *pcapReader) walkOptions(options []byte, f func(code uint16, value []byte)) {
+	for len(options) >= 4 {
+		code := p.order.Uint16(options)
+		n := int(p.order.Uint16(options[2:]))
+		options = options[4:]
+		if code == 0 || n > len(options) {
+			return
+		}
+		f(code, options[:n])
+		padded := (n + 3) &^ 3
+		options = options[min(padded, len(options)):]
+	}
+}
+
+// time converts a timestamp in units of the interface's time resolution.
+func (i pcapngInterface) time(ts uint64) time.Time {
+	var unitsPerSecond uint64
+	if i.tsResol&0x80 != 0 {
+		unitsPerSecond = 1 << min(i.tsResol&0x7f, 63)
+	} else {
+		unitsPerSecond = uint64(math.Pow10(int(min(i.tsResol, 19))))
+	}
+	sec, units := ts/unitsPerSecond, ts%unitsPerSecond
+	hi, lo := bits.Mul64(units, uint64(time.Second))
+	nsec, _ := bits.Div64(hi, lo, unitsPerSecond)
+	return time.Unix(int64(sec), int64(nsec))
+}
+
+// pcapFlow is the network and transport layer summary of a packet.
+type pcapFlow struct {
+	Protocol         uint8
+	Src, Dst         netip.Addr
+	SrcPort, DstPort uint16
+	HasPorts         bool
+}
+
+// key returns the item key of the packet, or false if the packet carries no IP (or, for the dport key, no port).
+func (p pcapPacket) key(key string) (string, bool) {
+	f, ok := p.flow()
+	if !ok {
+		return "", false
+	}
+	switch key {
+	case pcapKeySrc:
+		return f.Src.String(), true
+	case pcapKeyDst:
+		return f.Dst.String(), true
+	case pcapKeyDstPort:
+		if !f.HasPorts {
+			return "", false
+		}
+		return fmt.Sprintf("%s/%d", ipProtocolName(f.Protocol), f.DstPort), true
+	default:
+		if !f.HasPorts {
+			return fmt.Sprintf("%s %s > %s", ipProtocolName(f.Protocol), f.Src, f.Dst), true
+		}
+		return fmt.Sprintf("%s %s > %s", ipProtocolName(f.Protocol),
+			netip.AddrPortFrom(f.Src, f.SrcPort),
+			netip.AddrPortFrom(f.Dst, f.DstPort)), true
+	}
+}
+
+func (p pcapPacket) flow() (pcapFlow, bool) {
+	data := p.Data
+	var etherType uint16
+	switch p.LinkType {
+	case linkTypeEthernet:
+		if len(data) < 14 {
+			return pcapFlow{}, false
+		}
+		etherType = binary.BigEndian.Uint16(data[12:])
+		data = data[14:]
+		for etherType == etherTypeVLAN || etherType == etherTypeQinQ || etherType == etherTypeQinQ1 {
+			if len(data) < 4 {
+				return pcapFlow{}, false
+			}
+			etherType = binary.BigEndian.Uint16(data[2:])
+			data = data[4:]
+		}
+	case linkTypeLinuxSLL:
+		if len(data) < 16 {
+			return pcapFlow{}, false
+		}
+		etherType = binary.BigEndian.Uint16(data[14:])
+		data = data[16:]
+	case linkTypeLinuxSL2:
+		if len(data) < 20 {
+			return pcapFlow{}, false
+		}
+		etherType = binary.BigEndian.Uint16(data)
+		data = data[20:]
+	case linkTypeNull, linkTypeLoop:
+		if len(data) < 4 {
+			return pcapFlow{}, false
+		}
+		data = data[4:]
+	case linkTypeRaw, linkTypeIPv4, linkTypeIPv6:
+	default:
+		return pcapFlow{}, false
+	}
+	if etherType == 0 && len(data) > 0 { // no link-layer protocol information: use the IP version
+		switch data[0] >> 4 {
+		case 4:
+			etherType = etherTypeIPv4
+		case 6:
+			etherType = etherTypeIPv6
+		}
+	}
+	switch etherType {
+	case etherTypeIPv4:
+		return parseIPv4(data)
+	case etherTypeIPv6:
+		return parseIPv6(data)
+	}
+	return pcapFlow{}, false
+}
+
+func parseIPv4(data []byte) (pcapFlow, bool) {
+	if len(data) < 20 {
+		return pcapFlow{}, false
+	}
+	headerLen := int(data[0]&0x0f) * 4
+	if headerLen < 20 {
+		return pcapFlow{}, false
+	}
+	f := pcapFlow{
+		Protocol: data[9],
+		Src:      netip.AddrFrom4([4]byte(data[12:16])),
+		Dst:      netip.AddrFrom4([4]byte(data[16:20])),
+	}
+	fragmentOffset := binary.BigEndian.Uint16(data[6:]) & 0x1fff
+	if fragmentOffset == 0 && len(data) >= headerLen {
+		f.parsePorts(data[headerLen:])
+	}
+	return f, true
+}
+
+func parseIPv6(data []byte) (pcapFlow, bool) {
+	if len(data) < 40 {
+		return pcapFlow{}, false
+	}
+	f := pcapFlow{
+		Protocol: data[6],
+		Src:      netip.AddrFrom16([16]byte(data[8:24])),
+		Dst:      netip.AddrFrom16([16]byte(data[24:40])),
+	}
+	data = data[40:]
+	for {
+		switch f.Protocol {
+		case 0, 43, 60: // hop-by-hop, routing, destination options
+			if len(data) < 8 {
+				return f, true
+			}
+			f.Protocol = data[0]
+			n := (int(data[1]) + 1) * 8
+			data = data[min(n, len(data)):]
+			continue
+		case 44: // fragment
+			if len(data) < 8 {
+				return f, true
+			}
+			f.Protocol = data[0]
+			if binary.BigEndian.Uint16(data[2:])&0xfff8 != 0 {
+				return f, true // not the first fragment: no transport header
+			}
+			data = data[8:]
+			continue
+		}
+		break
+	}
+	f.parsePorts(data)
+	return f, true
+}
+
+func (f *pcapFlow) parsePorts(data []byte) {
+	switch f.Protocol {
+	case 6, 17, 132: // TCP, UDP, SCTP
+		if len(data) < 4 {
+			return
+		}
+		f.SrcPort = binary.BigEndian.Uint16(data)
+		f.DstPort = binary.BigEndian.Uint16(data[2:])
+		f.HasPorts = true
+	}
+}
+
+func ipProtocolName(protocol uint8) string {
+	switch protocol {
+	case 1:
+		return "icmp"
+	case 6:
+		return "tcp"
+	case 17:
+		return "udp"
+	case 58:
+		return "icmpv6"
+	case 132:
+		return "sctp"
+	}
+	return fmt.Sprintf("ip-proto-%d", protocol)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// packetHex decodes a hex dump, ignoring whitespace.
+func packetHex(t *testing.T, dump string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(strings.Join(strings.Fields(dump), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+const (
+	// IPv4 header (10.0.0.1 > 10.0.0.2, TCP) and TCP ports 53211 > 443
+	ipv4TCP = "45000028 00000000 40060000 0a000001 0a000002  cfdb01bb"
+	// IPv6 header (2001:db8::1 > 2001:db8::2, UDP) and UDP ports 5353 > 53
+	ipv6UDP = "60000000 00081140 20010db8000000000000000000000001 20010db8000000000000000000000002  14e90035"
+)
+
+func classicPcap(order binary.ByteOrder, magic uint32, linkType uint32, packets ...[]byte) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, order, []uint32{magic, 0x00040002, 0, 0, 65535, linkType})
+	for i, p := range packets {
+		binary.Write(&b, order, []uint32{1700000000, uint32(i + 1), uint32(len(p)), uint32(len(p) + 100)})
+		b.Write(p)
+	}
+	return b.Bytes()
+}
+
+func pcapngBlock(order binary.ByteOrder, blockType uint32, body []byte) []byte {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	var b bytes.Buffer
+	binary.Write(&b, order, []uint32{blockType, uint32(len(body) + 12)})
+	b.Write(body)
+	binary.Write(&b, order, uint32(len(body)+12))
+	return b.Bytes()
+}
+
+func pcapngFile(order binary.ByteOrder, linkType uint16, tsResol int, ts uint64, packet []byte) []byte {
+	var shb, idb, epb bytes.Buffer
+	binary.Write(&shb, order, uint32(pcapngByteOrderMagic))
+	binary.Write(&shb, order, []uint16{1, 0})
+	binary.Write(&shb, order, int64(-1))
+	binary.Write(&idb, order, []uint16{linkType, 0})
+	binary.Write(&idb, order, uint32(65535))
+	if tsResol >= 0 {
+		binary.Write(&idb, order, []uint16{pcapngOptionTsResol, 1})
+		idb.Write([]byte{byte(tsResol), 0, 0, 0})
+		binary.Write(&idb, order, []uint16{0, 0})
+	}
+	binary.Write(&epb, order, []uint32{0, uint32(ts >> 32), uint32(ts), uint32(len(packet)), uint32(len(packet))})
+	epb.Write(packet)
+	var b bytes.Buffer
+	b.Write(pcapngBlock(order, pcapngBlockSHB, shb.Bytes()))
+	b.Write(pcapngBlock(order, pcapngBlockIDB, idb.Bytes()))
+	b.Write(pcapngBlock(order, 0x00000bad, []byte{1, 2, 3, 4})) // unknown blocks are skipped
+	b.Write(pcapngBlock(order, pcapngBlockEPB, epb.Bytes()))
+	return b.Bytes()
+}
+
+func readPcap(t *testing.T, data []byte) []pcapPacket {
+	t.Helper()
+	r, err := newPcapReader(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var packets []pcapPacket
+	for {
+		p, err := r.Next()
+		if err == io.EOF {
+			return packets
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, p)
+	}
+}
+
+func TestPcapReader(t *testing.T) {
+	ether := packetHex(t, "000000000002 000000000001 0800"+ipv4TCP)
+	raw := packetHex(t, ipv4TCP)
+	tests := []struct {
+		name     string
+		data     []byte
+		time     time.Time
+		linkType uint16
+		length   int
+		flow     string
+	}{
+		{
+			"classic micros little endian",
+			classicPcap(binary.LittleEndian, pcapMagicMicros, linkTypeEthernet, ether),
+			time.Unix(1700000000, 1000), linkTypeEthernet, len(ether) + 100,
+			"tcp 10.0.0.1:53211 > 10.0.0.2:443",
+		},
+		{
+			"classic nanos big endian",
+			classicPcap(binary.BigEndian, pcapMagicNanos, linkTypeRaw, raw),
+			time.Unix(1700000000, 1), linkTypeRaw, len(raw) + 100,
+			"tcp 10.0.0.1:53211 > 10.0.0.2:443",
+		},
+		{
+			"pcapng default micros",
+			pcapngFile(binary.LittleEndian, linkTypeEthernet, -1, 1700000000_000002, ether),
+			time.Unix(1700000000, 2000), linkTypeEthernet, len(ether),
+			"tcp 10.0.0.1:53211 > 10.0.0.2:443",
+		},
+		{
+			"pcapng if_tsresol nanos big endian",
+			pcapngFile(binary.BigEndian, linkTypeRaw, 9, 1700000000_000000003, raw),
+			time.Unix(1700000000, 3), linkTypeRaw, len(raw),
+			"tcp 10.0.0.1:53211 > 10.0.0.2:443",
+		},
+		{
+			"pcapng if_tsresol power of two",
+			pcapngFile(binary.LittleEndian, linkTypeRaw, 0x80|10, 1700000000<<10|512, raw),
+			time.Unix(1700000000, 500_000_000), linkTypeRaw, len(raw),
+			"tcp 10.0.0.1:53211 > 10.0.0.2:443",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packets := readPcap(t, tt.data)
+			if len(packets) != 1 {
+				t.Fatalf("got %d packets, want 1", len(packets))
+			}
+			p := packets[0]
+			if !p.Time.Equal(tt.time) {
+				t.Errorf("got time %v, want %v", p.Time, tt.time)
+			}
+			if p.LinkType != tt.linkType {
+				t.Errorf("got link type %d, want %d", p.LinkType, tt.linkType)
+			}
+			if p.Length != tt.length {
+				t.Errorf("got length %d, want %d", p.Length, tt.length)
+			}
+			if flow, _ := p.key(pcapKeyFlow); flow != tt.flow {
+				t.Errorf("got flow %q, want %q", flow, tt.flow)
+			}
+		})
+	}
+}
+
+func TestPcapReaderInvalid(t *testing.T) {
+	hugePacket := classicPcap(binary.LittleEndian, pcapMagicMicros, linkTypeRaw)
+	hugePacket = binary.LittleEndian.AppendUint32(hugePacket, 1700000000)
+	hugePacket = binary.LittleEndian.AppendUint32(hugePacket, 0)
+	hugePacket = binary.LittleEndian.AppendUint32(hugePacket, pcapMaxPacketSize+1)
+	hugePacket = binary.LittleEndian.AppendUint32(hugePacket, pcapMaxPacketSize+1)
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"unknown magic", make([]byte, 24)},
+		{"huge packet", hugePacket},
+		{"pcapng packet before interface", pcapngBlock(binary.LittleEndian, pcapngBlockEPB, make([]byte, 20))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := newPcapReader(bufio.NewReader(bytes.NewReader(tt.data)))
+			if err == nil {
+				_, err = r.Next()
+			}
+			if err == nil || err == io.EOF {
+				t.Errorf("got error %v, want an invalid data error", err)
+			}
+		})
+	}
+}
+
+func TestPcapPacketKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		linkType uint16
+		data     string
+		key      string
+		want     string
+		ok       bool
+	}{
+		{"ethernet src", linkTypeEthernet, "000000000002 000000000001 0800" + ipv4TCP, pcapKeySrc, "10.0.0.1", true},
+		{"ethernet dst", linkTypeEthernet, "000000000002 000000000001 0800" + ipv4TCP, pcapKeyDst, "10.0.0.2", true},
+		{"ethernet dport", linkTypeEthernet, "000000000002 000000000001 0800" + ipv4TCP, pcapKeyDstPort, "tcp/443", true},
+		{"vlan", linkTypeEthernet, "000000000002 000000000001 8100 0064 0800" + ipv4TCP, pcapKeyDstPort, "tcp/443", true},
+		{"qinq", linkTypeEthernet, "000000000002 000000000001 88a8 0064 8100 00c8 86dd" + ipv6UDP, pcapKeyDstPort, "udp/53", true},
+		{"non-ip ethernet", linkTypeEthernet, "000000000002 000000000001 0806 0001", pcapKeySrc, "", false},
+		{"linux sll", linkTypeLinuxSLL, "0000 0001 0006 000000000001 0000 0800" + ipv4TCP, pcapKeySrc, "10.0.0.1", true},
+		{"linux sll2", linkTypeLinuxSL2, "0800 0000 00000002 0001 00 06 000000000001 0000" + ipv4TCP, pcapKeySrc, "10.0.0.1", true},
+		{"null ipv4", linkTypeNull, "02000000" + ipv4TCP, pcapKeyFlow, "tcp 10.0.0.1:53211 > 10.0.0.2:443", true},
+		{"loop ipv6", linkTypeLoop, "0000001e" + ipv6UDP, pcapKeyFlow, "udp [2001:db8::1]:5353 > [2001:db8::2]:53", true},
+		{"raw ipv6", linkTypeIPv6, ipv6UDP, pcapKeyDst, "2001:db8::2", true},
+		{"unknown link type", 12345, ipv4TCP, pcapKeySrc, "", false},
+		{"truncated ipv4", linkTypeRaw, "45000028 00000000 4006", pcapKeySrc, "", false},
+		{"ipv4 without ports", linkTypeRaw, "45000028 00000000 40010000 0a000001 0a000002", pcapKeyFlow, "icmp 10.0.0.1 > 10.0.0.2", true},
+		{"ipv4 no ports for dport", linkTypeRaw, "45000028 00000000 40010000 0a000001 0a000002", pcapKeyDstPort, "", false},
+		{"ipv4 later fragment", linkTypeRaw, "45000028 00000010 40060000 0a000001 0a000002 cfdb01bb", pcapKeyFlow, "tcp 10.0.0.1 > 10.0.0.2", true},
+		{
+			"ipv6 hop-by-hop and destination options",
+			linkTypeRaw,
+			"60000000 00180040 20010db8000000000000000000000001 20010db8000000000000000000000002" +
+				"3c000000 00000000" + // hop-by-hop options, next header: destination options
+				"11000000 00000000" + // destination options, next header: UDP
+				"14e90035",
+			pcapKeyFlow, "udp [2001:db8::1]:5353 > [2001:db8::2]:53", true,
+		},
+		{
+			"ipv6 routing header",
+			linkTypeRaw,
+			"60000000 00202b40 20010db8000000000000000000000001 20010db8000000000000000000000002" +
+				"06020000 00000000 00000000 00000000 00000000 00000000" + // routing header (3 units), next header: TCP
+				"cfdb01bb",
+			pcapKeyDstPort, "tcp/443", true,
+		},
+		{
+			"ipv6 first fragment",
+			linkTypeRaw,
+			"60000000 00102c40 20010db8000000000000000000000001 20010db8000000000000000000000002" +
+				"11000001 00000001" + // fragment header at offset 0 with more fragments, next header: UDP
+				"14e90035",
+			pcapKeyFlow, "udp [2001:db8::1]:5353 > [2001:db8::2]:53", true,
+		},
+		{
+			"ipv6 later fragment",
+			linkTypeRaw,
+			"60000000 00102c40 20010db8000000000000000000000001 20010db8000000000000000000000002" +
+				"110000b8 00000001" + // fragment header at offset 23*8, next header: UDP
+				"14e90035",
+			pcapKeyFlow, "udp 2001:db8::1 > 2001:db8::2", true,
+		},
+		{
+			"ipv6 truncated extension header",
+			linkTypeRaw,
+			"60000000 00100000 20010db8000000000000000000000001 20010db8000000000000000000000002 1100",
+			pcapKeyFlow, "ip-proto-0 2001:db8::1 > 2001:db8::2", true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := pcapPacket{LinkType: tt.linkType, Data: packetHex(t, tt.data)}
+			got, ok := p.key(tt.key)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("got %q, %v, want %q, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pollPrometheusItems periodically scrapes the configured target and counts the per-item increase of the selected counters.
+func (m *model) pollPrometheusItems() {
+	m.timestampsFromData.Store(false)
+	// a file is usually written once (or rarely), so its counters are counted from zero rather than from the first poll
+	p := &promPoller{fromZero: !isPrometheusURL(config.PrometheusURL)}
+	ticker := time.NewTicker(config.PrometheusInterval)
+	for ; ; <-ticker.C {
+		m.pollPrometheus(p)
+	}
+}
+
+// pollPrometheus scrapes the configured target once, and counts the per-item increases since the previous scrape.
+func (m *model) pollPrometheus(p *promPoller) {
+	samples, err := scrapePrometheus(config.PrometheusURL, config.PrometheusInterval)
+	m.mu.Lock()
+	m.scrapeErr = err
+	m.mu.Unlock()
+	if err != nil {
+		return
+	}
+	for item, count := range p.increases(samples) {
+		m.countRecord(record{Item: item, Count: count}, time.Time{})
+	}
+}
+
+// promPoller computes the per-item increases of the selected counters between scrapes.
+type promPoller struct {
+	previous   map[string]float64 // series values of the previous scrape
+	remainders map[string]float64 // fractional increases not counted yet, per item
+	fromZero   bool               // whether new series count their whole value, instead of only setting a baseline
+}
+
+// increases returns the whole per-item increases since the previous scrape, and keeps the fractional rest of each
+// item's increase for the next scrape. Series that are new (and all series on the first scrape) only set a baseline,
+// unless fromZero is set.
+func (p *promPoller) increases(samples []promSample) map[string]int {
+	current := make(map[string]float64, len(samples))
+	deltas := make(map[string]float64)
+	for _, s := range samples {
+		if !s.selected(config.PrometheusMetric) {
+			continue
+		}
+		key := s.series()
+		item := key
+		if config.PrometheusLabel != "" {
+			var ok bool
+			if item, ok = s.Labels[config.PrometheusLabel]; !ok {
+				continue
+			}
+		}
+		current[key] = s.Value
+		prev, ok := p.previous[key]
+		if !ok && !p.fromZero {
+			continue // new series: use as baseline
+		}
+		delta := s.Value - prev
+		if s.Value < prev { // counter reset
+			delta = s.Value
+		}
+		deltas[item] += delta
+	}
+	p.previous = current
+
+	counts := make(map[string]int)
+	remainders := make(map[string]float64, len(deltas))
+	for item, delta := range deltas {
+		if math.IsNaN(delta) || delta < 0 {
+			continue
+		}
+		delta += p.remainders[item]
+		whole := math.Floor(delta)
+		remainders[item] = delta - whole
+		if whole >= 1 {
+			counts[item] = int(min(whole, math.MaxInt32))
+		}
+	}
+	p.remainders = remainders
+	return counts
+}
+
+func (m *model) prometheusStatus() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.scrapeErr != nil {
+		return "scrape failed: " + m.scrapeErr.Error()
+	}
+	return "scraping " + config.PrometheusURL
+}
+
+// promSample is a single sample from the Prometheus text exposition format.
+type promSample struct {
+	Name   string
+	Type   string // Type of the metric family, from the `# TYPE` line (if any).
+	Labels map[string]string
+	Value  float64
+}
+
+// selected reports whether the sample belongs to the given metric, or (if none is given) to any counter.
+func (s promSample) selected(metric string) bool {
+	if metric == "" {
+		return s.Type == "counter"
+	}
+	return s.Name == metric || s.Name == metric+"_total"
+}
+
+// series returns the canonical series name, e.g. `http_requests_total{method="GET",path="/"}`.
+func (s promSample) series() string {
+	if len(s.Labels) == 0 {
+		return s.Name
+	}
+	names := make([]string, 0, len(s.Labels))
+	for name := range s.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	sb.WriteString(s.Name)
+	sb.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(name)
+		sb.WriteByte('=')
+		sb.WriteString(strconv.Quote(s.Labels[name]))
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+// scrapePrometheus fetches and parses the exposition from an http(s) URL, or reads it from a file.
+func scrapePrometheus(target string, timeout time.Duration) ([]promSample, error) {
+	if !isPrometheusURL(target) {
+		f, err := os.Open(target)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return parsePrometheusText(f)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/plain;version=0.0.4")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("scrape %s: %s", target, resp.Status)
+	}
+	return parsePrometheusText(resp.Body)
+}
+
+// isPrometheusURL reports whether the target is an http(s) URL rather than a file path.
+func isPrometheusURL(target string) bool {
+	return strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://")
+}
+
+var errPromInvalid = errors.New("prometheus: invalid exposition line")
+
+// parsePrometheusText parses the Prometheus text exposition format,
+// see https://prometheus.io/docs/instrumenting/exposition_formats/#text-based-format
+func parsePrometheusText(r io.Reader) ([]promSample, error) {
+	var samples []promSample
+	types := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			fields := strings.Fields(line)
+			if len(fields) >= 4 && fields[1] == "TYPE" {
+				types[fields[2]] = fields[3]
+			}
+			continue
+		}
+		s, err := parsePromSample(line)
+		if err != nil {
+			continue
+		}
+		s.Type = types[s.Name]
+		if s.Type == "" {
+			s.Type = types[strings.TrimSuffix(s.Name, "_total")]
+		}
+		samples = append(samples, s)
+	}
+	return samples, scanner.Err()
+}
+
+func parsePromSample(line string) (promSample, error) {
+	var s promSample
+	end := strings.IndexAny(line, "{ \t")
+	if end <= 0 {
+		return s, errPromInvalid
+	}
+	s.Name, line = line[:end], line[end:]
+	if line[0] == '{' {
+		s.Labels = make(map[string]string)
+		line = line[1:]
+		for {
+			line = strings.TrimLeft(line, " \t,")
+			if line == "" {
+				return s, errPromInvalid
+			}
+			if line[0] == '}' {
+				line = line[1:]
+				break
+			}
+			eq := strings.IndexByte(line, '=')
+			if eq <= 0 || eq+1 >= len(line) || line[eq+1] != '"' {
+				return s, errPromInvalid
+			}
+			name := strings.TrimSpace(line[:eq])
+			value, rest, err := parsePromLabelValue(line[eq+2:])
+			if err != nil {
+				return s, err
+			}
+			s.Labels[name] = value
+			line = rest
+		}
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return s, errPromInvalid
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return s, err
+	}
+	s.Value = value
+	return s, nil
+}
+
+// parsePromLabelValue parses an escaped label value up to the closing quote, and returns the rest of the line.
+func parsePromLabelValue(line string) (string, string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; c {
+		case '"':
+			return sb.String(), line[i+1:], nil
+		case '\\':
+			i++
+			if i == len(line) {
+				return "", "", errPromInvalid
+			}
+			switch line[i] {
+			case 'n':
+				sb.WriteByte('\n')
+			default:
+				sb.WriteByte(line[i])
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return "", "", errPromInvalid
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePromSample(t *testing.T) {
+	tests := []struct {
+		line string
+		want promSample
+	}{
+		{"up 1", promSample{Name: "up", Value: 1}},
+		{"up\t1 1700000000000", promSample{Name: "up", Value: 1}},
+		{"x_total +Inf", promSample{Name: "x_total", Value: math.Inf(1)}},
+		{
+			`http_requests_total{method="GET",path="/a b"} 1027`,
+			promSample{Name: "http_requests_total", Labels: map[string]string{"method": "GET", "path": "/a b"}, Value: 1027},
+		},
+		{
+			`x{ a="1" , b="2",} 3`,
+			promSample{Name: "x", Labels: map[string]string{"a": "1", "b": "2"}, Value: 3},
+		},
+		{
+			`x{path="C:\\dir\\",msg="say \"hi\"\nbye",brace="}"} 2.5e3`,
+			promSample{Name: "x", Labels: map[string]string{"path": `C:\dir\`, "msg": "say \"hi\"\nbye", "brace": "}"}, Value: 2500},
+		},
+		{`x{} 4`, promSample{Name: "x", Labels: map[string]string{}, Value: 4}},
+	}
+	for _, tt := range tests {
+		got, err := parsePromSample(tt.line)
+		if err != nil {
+			t.Errorf("parsePromSample(%q): %v", tt.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parsePromSample(%q) = %#v, want %#v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestParsePromSampleInvalid(t *testing.T) {
+	for _, line := range []string{
+		`{a="1"} 1`,
+		`x`,
+		`x{a="1"}`,
+		`x{a="1" 1`,
+		`x{a=1} 1`,
+		`x{a="1\`,
+		`x{a="1} 1`,
+		`x one`,
+	} {
+		if s, err := parsePromSample(line); err == nil {
+			t.Errorf("parsePromSample(%q) = %#v, want an error", line, s)
+		}
+	}
+}
+
+func TestParsePrometheusText(t *testing.T) {
+	input := `# HELP http_requests_total Requests.
+# TYPE http_requests_total counter
+http_requests_total{path="/a"} 3
+
+# TYPE process_cpu_seconds counter
+process_cpu_seconds_total 1.5
+# TYPE temperature gauge
+temperature 21
+untyped_metric 7
+not a valid line
+`
+	samples, err := parsePrometheusText(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []promSample{
+		{Name: "http_requests_total", Type: "counter", Labels: map[string]string{"path": "/a"}, Value: 3},
+		{Name: "process_cpu_seconds_total", Type: "counter", Value: 1.5},
+		{Name: "temperature", Type: "gauge", Value: 21},
+		{Name: "untyped_metric", Value: 7},
+	}
+	if !reflect.DeepEqual(samples, want) {
+		t.Errorf("got %#v, want %#v", samples, want)
+	}
+}
+
+func TestPollPrometheus(t *testing.T) {
+	var exposition string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if exposition == "" {
+			http.Error(w, "gone", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(exposition))
+	}))
+	defer srv.Close()
+	m := testModel(t, func(c *Config) {
+		c.PrometheusURL = srv.URL
+		c.PrometheusLabel = "path"
+	})
+	p := &promPoller{}
+
+	steps := []struct {
+		name       string
+		exposition string
+		want       map[string]uint32
+		failed     bool
+	}{
+		{
+			"baseline",
+			`# TYPE http_requests_total counter
+http_requests_total{path="/a",code="200"} 10
+http_requests_total{path="/a",code="500"} 1
+http_requests_total{path="/b"} 5
+http_request_seconds_total{path="/b"} 0.3
+`,
+			map[string]uint32{},
+			false,
+		},
+		{
+			"deltas summed per label value",
+			`# TYPE http_requests_total counter
+# TYPE http_request_seconds_total counter
+http_requests_total{path="/a",code="200"} 17
+http_requests_total{path="/a",code="500"} 3
+http_requests_total{path="/b"} 5
+http_request_seconds_total{path="/b"} 0.7
+http_requests_total{path="/c"} 100
+`,
+			map[string]uint32{"/a": 9},
+			false,
+		},
+		{
+			"counter reset and fractional carry",
+			`# TYPE http_requests_total counter
+# TYPE http_request_seconds_total counter
+http_requests_total{path="/a",code="200"} 2
+http_requests_total{path="/a",code="500"} 3
+http_requests_total{path="/b"} 5
+http_request_seconds_total{path="/b"} 1.5
+http_requests_total{path="/c"} 104
+`,
+			map[string]uint32{"/a": 2, "/c": 4},
+			false,
+		},
+		{"scrape error", "", map[string]uint32{}, true},
+		{
+			"recovered",
+			`# TYPE http_request_seconds_total counter
+http_request_seconds_total{path="/b"} 2.3
+`,
+			map[string]uint32{"/b": 1},
+			false,
+		},
+	}
+	for _, step := range steps {
+		exposition = step.exposition
+		m.pollPrometheus(p)
+		if got := queuedCounts(m); !reflect.DeepEqual(got, step.want) {
+			t.Errorf("%s: got counts %v, want %v", step.name, got, step.want)
+		}
+		if failed := m.scrapeErr != nil; failed != step.failed {
+			t.Errorf("%s: got scrape error %v", step.name, m.scrapeErr)
+		}
+		if status := m.prometheusStatus(); strings.HasPrefix(status, "scrape failed") != step.failed {
+			t.Errorf("%s: got status %q", step.name, status)
+		}
+	}
+}
+
+func TestPollPrometheusFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.prom")
+	m := testModel(t, func(c *Config) {
+		c.PrometheusURL = path
+		c.PrometheusLabel = "path"
+	})
+	p := &promPoller{fromZero: !isPrometheusURL(path)}
+
+	steps := []struct {
+		name       string
+		exposition string
+		want       map[string]uint32
+	}{
+		{
+			"counted from zero",
+			`# TYPE http_requests_total counter
+http_requests_total{path="/a"} 10
+http_requests_total{path="/b"} 5
+`,
+			map[string]uint32{"/a": 10, "/b": 5},
+		},
+		{
+			"unchanged",
+			`# TYPE http_requests_total counter
+http_requests_total{path="/a"} 10
+http_requests_total{path="/b"} 5
+`,
+			map[string]uint32{},
+		},
+		{
+			"increase and new series",
+			`# TYPE http_requests_total counter
+http_requests_total{path="/a"} 12
+http_requests_total{path="/b"} 5
+http_requests_total{path="/c"} 3
+`,
+			map[string]uint32{"/a": 2, "/c": 3},
+		},
+	}
+	for _, step := range steps {
+		if err := os.WriteFile(path, []byte(step.exposition), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		m.pollPrometheus(p)
+		if m.scrapeErr != nil {
+			t.Fatalf("%s: got scrape error %v", step.name, m.scrapeErr)
+		}
+		if got := queuedCounts(m); !reflect.DeepEqual(got, step.want) {
+			t.Errorf("%s: got counts %v, want %v", step.name, got, step.want)
+		}
+	}
+}
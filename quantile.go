@@ -0,0 +1,173 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/keilerkonzept/topk/heap"
+)
+
+// quantileLevels are the -value quantiles shown for the selected item; the list shows listQuantileLevels.
+var (
+	quantileLevels     = []float64{0.5, 0.9, 0.95, 0.99, 0.999}
+	listQuantileLevels = []float64{0.5, 0.95, 0.99}
+)
+
+// quantileGamma is the ratio between the bounds of a histogram bin, for a relative error of about (γ-1)/(γ+1) = 1%.
+const quantileGamma = 1.02
+
+var quantileLogGamma = math.Log(quantileGamma)
+
+// zeroBin is the histogram bin of zero and negative values.
+const zeroBin = math.MinInt32
+
+func valueBin(v float64) int32 {
+	if v <= 0 {
+		return zeroBin
+	}
+	return int32(math.Ceil(math.Log(v) / quantileLogGamma))
+}
+
+// binValue returns the value representing the bin, with the same relative distance to both of its bounds.
+func binValue(bin int32) float64 {
+	if bin == zeroBin {
+		return 0
+	}
+	return 2 * math.Exp(float64(bin)*quantileLogGamma) / (quantileGamma + 1)
+}
+
+// valueHistogram is a log-bucketed histogram (as in DDSketch) with one set of bins per segment of the window.
+type valueHistogram [windowSegments]map[int32]uint32
+
+func (h *valueHistogram) add(segment int, value float64, count uint32) {
+	if h[segment] == nil {
+		h[segment] = make(map[int32]uint32)
+	}
+	h[segment][valueBin(value)] += count
+}
+
+// quantiles returns the estimated quantiles over all segments, or nil if the histogram is empty.
+func (h *valueHistogram) quantiles(qs []float64) []float64 {
+	merged := make(map[int32]uint64)
+	var total uint64
+	for _, bins := range h {
+		for bin, count := range bins {
+			merged[bin] += uint64(count)
+			total += uint64(count)
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+	bins := make([]int32, 0, len(merged))
+	for bin := range merged {
+		bins = append(bins, bin)
+	}
+	slices.Sort(bins)
+	out := make([]float64, len(qs))
+	var (
+		rank uint64
+		i    int
+	)
+	for _, bin := range bins {
+		rank += merged[bin]
+		for ; i < len(qs) && float64(rank) >= qs[i]*float64(total); i++ {
+			out[i] = binValue(bin)
+		}
+	}
+	for ; i < len(qs); i++ {
+		out[i] = binValue(bins[len(bins)-1])
+	}
+	return out
+}
+
+// quantileCounter keeps a histogram of the -value field values of each item in a sketch's top-K heap.
+type quantileCounter struct {
+	items map[string]*valueHistogram
+	clock segmentClock
+}
+
+func newQuantileCounter(windowSize int) *quantileCounter {
+	return &quantileCounter{
+		items: make(map[string]*valueHistogram),
+		clock: newSegmentClock(windowSize),
+	}
+}
+
+// Add records the value (count times) for the item, if the item is in the top-K heap.
+func (q *quantileCounter) Add(top *heap.Min, item string, value float64, count uint32) {
+	h, ok := q.items[item]
+	if !ok {
+		if !top.Contains(item) {
+			return
+		}
+		h = new(valueHistogram)
+		q.items[item] = h
+	}
+	h.add(q.clock.current, value, count)
+}
+
+// Ticks advances the segments, and stops tracking items that are no longer in the top-K heap.
+func (q *quantileCounter) Ticks(top *heap.Min, n int) {
+	started := q.clock.advance(n, func(segment int) {
+		for _, h := range q.items {
+			h[segment] = nil
+		}
+	})
+	if !started {
+		return
+	}
+	for item := range q.items {
+		if !top.Contains(item) {
+			delete(q.items, item)
+		}
+	}
+}
+
+// Quantiles returns the item's estimated quantiles, or nil if the item is not tracked or has no values.
+func (q *quantileCounter) Quantiles(item string) []float64 {
+	h, ok := q.items[item]
+	if !ok {
+		return nil
+	}
+	return h.quantiles(quantileLevels)
+}
+
+// valueQuantiles returns the estimated quantiles of the items' values in the given window
+// (nil for items without values), or nil without -value.
+func (m *model) valueQuantiles(window int, items []heap.Item) [][]float64 {
+	if config.Value == "" {
+		return nil
+	}
+	out := make([][]float64, len(items))
+	for i, item := range items {
+		sh := m.shards[shardIndex(item.Item, len(m.shards))]
+		sh.mu.Lock()
+		out[i] = sh.quantiles[window].Quantiles(item.Item)
+		sh.mu.Unlock()
+	}
+	return out
+}
+
+// quantileStatus returns the quantiles of the selected item.
+func (m *model) quantileStatus() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.list.Index()
+	if i >= len(m.listItems) || i >= len(m.listQuantiles) || m.listQuantiles[i] == nil {
+		return config.Value + ": no values"
+	}
+	return m.listItems[i].Item + " " + config.Value + " " + formatQuantiles(quantileLevels, m.listQuantiles[i])
+}
+
+// formatQuantiles formats the given quantiles among the estimates of all quantiles, e.g. p50:12 p95:40 p99:85.
+func formatQuantiles(qs []float64, estimates []float64) string {
+	parts := make([]string, len(qs))
+	for i, q := range qs {
+		v := estimates[slices.Index(quantileLevels, q)]
+		parts[i] = "p" + strconv.FormatFloat(q*100, 'f', -1, 64) + ":" + formatNumber(v)
+	}
+	return strings.Join(parts, " ")
+}
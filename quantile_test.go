@@ -0,0 +1,106 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/keilerkonzept/topk/heap"
+)
+
+// quantileMaxError is the relative error guaranteed by the histogram bins.
+const quantileMaxError = (quantileGamma - 1) / (quantileGamma + 1)
+
+func withinQuantileError(got, want float64) bool {
+	return math.Abs(got-want) <= (quantileMaxError+1e-9)*math.Abs(want)
+}
+
+func TestValueHistogramQuantiles(t *testing.T) {
+	qs := []float64{0.001, 0.1, 0.5, 0.9, 0.95, 0.99, 0.999, 1}
+	tests := []struct {
+		name   string
+		values []float64
+	}{
+		{"uniform", func() []float64 {
+			var values []float64
+			for i := range 10_000 {
+				values = append(values, float64(i+1))
+			}
+			return values
+		}()},
+		{"log-uniform", func() []float64 {
+			var values []float64
+			for i := range 10_000 {
+				values = append(values, 1e-3*math.Pow(1e9, float64(i)/10_000))
+			}
+			return values
+		}()},
+		{"constant", []float64{42, 42, 42}},
+	}
+	for _, tt := range tests {
+		var h valueHistogram
+		for i, v := range tt.values {
+			h.add(i%windowSegments, v, 1)
+		}
+		sorted := slices.Sorted(slices.Values(tt.values))
+		got := h.quantiles(qs)
+		for i, q := range qs {
+			want := sorted[int(math.Ceil(q*float64(len(sorted))))-1]
+			if !withinQuantileError(got[i], want) {
+				t.Errorf("%s: got p%v %v, want %v within %.2f%%", tt.name, 100*q, got[i], want, 100*quantileMaxError)
+			}
+		}
+	}
+}
+
+func TestValueHistogramZeroAndCounts(t *testing.T) {
+	var h valueHistogram
+	if got := h.quantiles(quantileLevels); got != nil {
+		t.Errorf("empty histogram: got %v, want nil", got)
+	}
+	h.add(0, -5, 1)
+	h.add(1, 0, 2)
+	h.add(2, 100, 7)
+	got := h.quantiles([]float64{0.1, 0.3, 0.31, 1})
+	if got[0] != 0 || got[1] != 0 || !withinQuantileError(got[2], 100) || !withinQuantileError(got[3], 100) {
+		t.Errorf("got %v, want [0 0 100 100]", got)
+	}
+}
+
+func TestQuantileCounter(t *testing.T) {
+	top := heap.NewMin(2)
+	top.Update("a", 0, 10)
+	q := newQuantileCounter(4) // 4 segments of 1 tick
+
+	q.Add(top, "b", 1, 1) // not in the heap
+	if got := q.Quantiles("b"); got != nil {
+		t.Errorf("got quantiles %v for b before it entered the heap, want nil", got)
+	}
+	q.Add(top, "a", 1000, 1)
+	q.Ticks(top, 1)
+	q.Add(top, "a", 10, 3)
+	if got := q.Quantiles("a"); !withinQuantileError(got[len(got)-1], 1000) {
+		t.Errorf("got quantiles %v for a, want the largest one at 1000", got)
+	}
+	q.Ticks(top, 3) // expires the segment of 1000
+	if got := q.Quantiles("a"); !withinQuantileError(got[len(got)-1], 10) {
+		t.Errorf("got quantiles %v for a after 4 ticks, want the largest one at 10", got)
+	}
+	q.Ticks(top, 1)
+	if got := q.Quantiles("a"); got != nil {
+		t.Errorf("got quantiles %v for a after a whole window, want nil", got)
+	}
+
+	top.Update("b", 0, 20)
+	top.Update("c", 0, 30) // replaces a
+	q.Ticks(top, 1)
+	if _, ok := q.items["a"]; ok {
+		t.Errorf("got a tracked after it left the heap")
+	}
+}
+
+func TestFormatQuantiles(t *testing.T) {
+	if got := formatQuantiles([]float64{0.5, 0.99}, []float64{1, 2, 3, 4, 5}); got != "p50:1 p99:4" {
+		t.Errorf("got %q, want %q", got, "p50:1 p99:4")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Units of the count display, see keys.Rate. Counts are shown as window sums, or as average rates over the window.
+const (
+	rateNone   = ""
+	rateSecond = "s"
+	rateMinute = "min"
+)
+
+var rateUnits = []string{rateNone, rateSecond, rateMinute}
+
+func rateUnitDuration(unit string) time.Duration {
+	if unit == rateMinute {
+		return time.Minute
+	}
+	return time.Second
+}
+
+// nextRateUnit switches the count display to the next unit.
+func (m *model) nextRateUnit() {
+	m.rateUnit.Store((m.rateUnit.Load() + 1) % int32(len(rateUnits)))
+}
+
+// unit returns the active unit of the count display.
+func (m *model) unit() string {
+	return rateUnits[m.rateUnit.Load()]
+}
+
+// formatCount formats a count over the given duration in the given unit, e.g. 1234 or 20.6/s.
+func formatCount(count float64, d time.Duration, unit string) string {
+	if unit == rateNone {
+		return fmt.Sprintf("%.0f", count)
+	}
+	return formatNumber(count*rateUnitDuration(unit).Seconds()/d.Seconds()) + "/" + unit
+}
+
+// formatNumber formats a number with an SI prefix, keeping up to 3 significant digits for small numbers, e.g. 0.25 or 1.2k.
+func formatNumber(v float64) string {
+	if v < 99.95 {
+		return fmt.Sprintf("%.3g", v)
+	}
+	return formatSI(v)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFormatCount(t *testing.T) {
+	tests := []struct {
+		count float64
+		d     time.Duration
+		unit  string
+		want  string
+	}{
+		{1234, time.Minute, rateNone, "1234"},
+		{1234.4, time.Minute, rateNone, "1234"},
+		{0, time.Minute, rateSecond, "0/s"},
+		{1, time.Minute, rateSecond, "0.0167/s"},
+		{1234, time.Minute, rateSecond, "20.6/s"},
+		{5999, time.Second, rateSecond, "6.0k/s"},
+		{1234, time.Minute, rateMinute, "1.2k/min"},
+		{30, 10 * time.Second, rateMinute, "180/min"},
+		{1, time.Hour, rateMinute, "0.0167/min"},
+	}
+	for _, tt := range tests {
+		if got := formatCount(tt.count, tt.d, tt.unit); got != tt.want {
+			t.Errorf("formatCount(%v, %v, %q) = %q, want %q", tt.count, tt.d, tt.unit, got, tt.want)
+		}
+	}
+}
+
+func TestFormatNumber(t *testing.T) {
+	tests := []struct {
+		v    float64
+		want string
+	}{
+		{0, "0"},
+		{0.0001, "0.0001"},
+		{0.25, "0.25"},
+		{1.2345, "1.23"},
+		{99.94, "99.9"},
+		{99.95, "100"},
+		{123.456, "123"},
+		{999.4, "999"},
+		{999.5, "1.0k"},
+		{12345, "12.3k"},
+		{999_949, "999.9k"},
+		{999_950, "1.0M"},
+		{2.5e9, "2.5G"},
+		{1e15, "1.0P"},
+	}
+	for _, tt := range tests {
+		if got := formatNumber(tt.v); got != tt.want {
+			t.Errorf("formatNumber(%v) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestNextRateUnit(t *testing.T) {
+	m := testModel(t, nil)
+	var got []string
+	for range len(rateUnits) + 1 {
+		got = append(got, m.unit())
+		m.nextRateUnit()
+	}
+	if want := []string{rateNone, rateSecond, rateMinute, rateNone}; !slices.Equal(got, want) {
+		t.Errorf("got units %q, want %q", got, want)
+	}
+}
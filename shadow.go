@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/keilerkonzept/topk/heap"
+)
+
+// exactCounter counts items exactly over sliding windows, using one hash map per tick.
+// Each shard has one, ticked together with its sketches, and serving as ground truth for -shadow-exact.
+type exactCounter struct {
+	ring    []map[string]uint32 // per-tick counts, ring[(head+age)%len(ring)] holds the counts from `age` ticks ago
+	head    int
+	windows []int               // window sizes in ticks
+	totals  []map[string]uint32 // per-window totals
+}
+
+func newExactCounter(windows []int) *exactCounter {
+	e := &exactCounter{
+		ring:    make([]map[string]uint32, max(1, maxOf(windows))),
+		windows: windows,
+		totals:  make([]map[string]uint32, len(windows)),
+	}
+	for i := range e.ring {
+		e.ring[i] = make(map[string]uint32)
+	}
+	for i := range e.totals {
+		e.totals[i] = make(map[string]uint32)
+	}
+	return e
+}
+
+func maxOf(values []int) int {
+	var out int
+	for _, v := range values {
+		out = max(out, v)
+	}
+	return out
+}
+
+func (e *exactCounter) Add(item string, count uint32) {
+	e.ring[e.head][item] += count
+	for _, totals := range e.totals {
+		totals[item] += count
+	}
+}
+
+func (e *exactCounter) Ticks(n int) {
+	if n >= len(e.ring) {
+		for _, counts := range e.ring {
+			clear(counts)
+		}
+		for _, totals := range e.totals {
+			clear(totals)
+		}
+		return
+	}
+	for range n {
+		e.tick()
+	}
+}
+
+func (e *exactCounter) tick() {
+	for w, size := range e.windows {
+		totals := e.totals[w]
+		for item, count := range e.ring[(e.head+size-1)%len(e.ring)] {
+			if totals[item] <= count {
+				delete(totals, item)
+			} else {
+				totals[item] -= count
+			}
+		}
+	}
+	e.head = (e.head + len(e.ring) - 1) % len(e.ring)
+	clear(e.ring[e.head])
+}
+
+// Count returns the exact count of the item in the given window.
+func (e *exactCounter) Count(window int, item string) uint32 {
+	return e.totals[window][item]
+}
+
+// TopK returns the k items with the largest exact counts in the given window.
+func (e *exactCounter) TopK(window, k int) []heap.Item {
+	return topItems(e.Items(window), k)
+}
+
+// updateExactCounts updates the exact counts of the list items. The caller must hold mu.
+func (m *model) updateExactCounts() {
+	if !config.ShadowExact {
+		return
+	}
+	m.listExact = make([]uint32, len(m.listItems))
+	for i, item := range m.listItems {
+		sh := m.shards[shardIndex(item.Item, len(m.shards))]
+		sh.mu.Lock()
+		m.listExact[i] = sh.exact.Count(m.window, item.Item)
+		sh.mu.Unlock()
+	}
+}
+
+// updateAccuracy updates the precision and recall of the listed top-k items. The caller must hold mu.
+func (m *model) updateAccuracy() {
+	if !config.ShadowExact {
+		return
+	}
+	var items []heap.Item
+	for _, sh := range m.shards {
+		sh.mu.Lock()
+		items = append(items, sh.exact.TopK(m.window, config.K)...)
+		sh.mu.Unlock()
+	}
+	exactTopK := make(map[string]bool, config.K)
+	for _, item := range topItems(items, config.K) {
+		exactTopK[item.Item] = true
+	}
+	var hits int
+	for _, item := range m.listItems {
+		if exactTopK[item.Item] {
+			hits++
+		}
+	}
+	m.precision, m.recall = 1, 1
+	if len(m.listItems) > 0 {
+		m.precision = float64(hits) / float64(len(m.listItems))
+	}
+	if len(exactTopK) > 0 {
+		m.recall = float64(hits) / float64(len(exactTopK))
+	}
+}
+
+func (m *model) exactStatus() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return fmt.Sprintf("top-%d precision %.1f%% recall %.1f%%", config.K, 100*m.precision, 100*m.recall)
+}
+
+// formatError formats the estimate's absolute and relative error w.r.t. the exact count.
+func formatError(estimate, exact uint32) string {
+	diff := int64(estimate) - int64(exact)
+	if exact == 0 {
+		return fmt.Sprintf("exact:%d err:%+d", exact, diff)
+	}
+	return fmt.Sprintf("exact:%d err:%+d (%+.1f%%)", exact, diff, 100*float64(diff)/float64(exact))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/keilerkonzept/topk"
+	"github.com/keilerkonzept/topk/heap"
+)
+
+func TestExactCounter(t *testing.T) {
+	e := newExactCounter([]int{2, 4})
+	// counts by tick age after the last step: 1a@3 1a@2 2b@1 2a@0 1c@0
+	e.Add("a", 1)
+	e.Ticks(1)
+	e.Add("a", 1)
+	e.Ticks(1)
+	e.Add("b", 2)
+	e.Ticks(1)
+	e.Add("a", 2)
+	e.Add("c", 1)
+
+	tests := []struct {
+		window int
+		item   string
+		want   uint32
+	}{
+		{0, "a", 2},
+		{0, "b", 2},
+		{0, "c", 1},
+		{1, "a", 4},
+		{1, "b", 2},
+		{1, "x", 0},
+	}
+	for _, tt := range tests {
+		if got := e.Count(tt.window, tt.item); got != tt.want {
+			t.Errorf("Count(%d, %q) = %d, want %d", tt.window, tt.item, got, tt.want)
+		}
+	}
+	if got, want := e.TopK(1, 2), []heap.Item{
+		{Item: "a", Count: 4, Fingerprint: topk.Fingerprint("a")},
+		{Item: "b", Count: 2, Fingerprint: topk.Fingerprint("b")},
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("TopK(1, 2) = %v, want %v", got, want)
+	}
+	if got, want := e.CountBetween("a", 0, 4), 4.0; got != want {
+		t.Errorf("CountBetween(a, 0, 4) = %v, want %v", got, want)
+	}
+
+	e.Ticks(2) // 1a@5 1a@4 2b@3 2a@2 1c@2
+	if got := e.Count(0, "a"); got != 0 {
+		t.Errorf("Count(0, a) = %d after 2 ticks, want 0", got)
+	}
+	if got := e.Count(1, "a"); got != 2 {
+		t.Errorf("Count(1, a) = %d after 2 ticks, want 2", got)
+	}
+	if got := e.Count(1, "b"); got != 2 {
+		t.Errorf("Count(1, b) = %d after 2 ticks, want 2", got)
+	}
+	if got := e.Count(1, "c"); got != 1 {
+		t.Errorf("Count(1, c) = %d after 2 ticks, want 1", got)
+	}
+	if got := e.Cardinality(); got != 3 {
+		t.Errorf("Cardinality() = %d after 2 ticks, want 3", got)
+	}
+
+	e.Ticks(4) // a full window: everything expires
+	for w := range 2 {
+		if items := e.Items(w); len(items) != 0 {
+			t.Errorf("Items(%d) = %v after a full window, want none", w, items)
+		}
+	}
+	e.Add("d", 7)
+	if got := e.Count(0, "d"); got != 7 {
+		t.Errorf("Count(0, d) = %d after a restart, want 7", got)
+	}
+}
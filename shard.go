@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/keilerkonzept/topk/heap"
+	"github.com/keilerkonzept/topk/sliding"
+)
+
+// shard is a hash partition of the items, with one sketch per window (or with -mode=decayed, one decayed sketch).
+// Each item is counted in exactly one shard, so the top-K items overall are among the shards' top-K items.
+type shard struct {
+	mu        sync.Mutex
+	sketches  []*sliding.Sketch
+	decayed   *decayedSketch     // with -mode=decayed, instead of the sketches
+	exact     *exactCounter      // exact counts of the shard's items, with -shadow-exact
+	auto      *autoExact         // exact counts used instead of the sketches while there are few items, with -exact-below
+	newItems  *newItemDetector   // items not seen in the last windows, with -new-windows
+	distinct  []*distinctCounter // per-window distinct counts of the shard's top-K items, with -distinct
+	quantiles []*quantileCounter // per-window value quantiles of the shard's top-K items, with -value
+
+	queue      chan ingestOp // updates to apply to the sketches, see applyQueued
+	overflowed atomic.Uint64 // records that found the queue full
+	dropped    atomic.Uint64 // records dropped by the -queue-overflow policy
+}
+
+func newShards(n int) []*shard {
+	shards := make([]*shard, n)
+	for i := range shards {
+		sh := &shard{
+			queue: make(chan ingestOp, config.QueueSize),
+		}
+		if config.Mode == modeDecayed {
+			sh.decayed = newDecayedSketch()
+		} else {
+			for _, window := range config.Windows {
+				sh.sketches = append(sh.sketches, newSketch(window))
+			}
+		}
+		windows := windowTicks()
+		if config.ShadowExact {
+			sh.exact = newExactCounter(windows)
+		}
+		if config.ExactBelow > 0 {
+			sh.auto = newAutoExact(windows, exactLimit())
+		}
+		if config.NewWindows > 0 {
+			sh.newItems = newNewItemDetector(maxOf(windows), newItemsCapacity())
+		}
+		for _, window := range windows {
+			if config.Distinct != "" {
+				sh.distinct = append(sh.distinct, newDistinctCounter(window))
+			}
+			if config.Value != "" {
+				sh.quantiles = append(sh.quantiles, newQuantileCounter(window))
+			}
+		}
+		shards[i] = sh
+	}
+	return shards
+}
+
+// heap returns the top-K heap of the given window. The caller must hold mu.
+func (sh *shard) heap(window int) *heap.Min {
+	if sh.decayed != nil {
+		return sh.decayed.Heap
+	}
+	return sh.sketches[window].Heap
+}
+
+// topK returns the shard's top-K items of the given window, by descending count. The caller must hold mu.
+func (sh *shard) topK(window int) []heap.Item {
+	switch {
+	case sh.decayed != nil:
+		return sh.decayed.SortedSlice()
+	case sh.exactActive():
+		return sh.auto.exact.TopK(window, sketchK())
+	}
+	return sh.sketches[window].SortedSlice()
+}
+
+// items returns the items of the given window: with exact counts, all of them, otherwise the top-K heap's items.
+// The caller must hold mu.
+func (sh *shard) items(window int) []heap.Item {
+	if sh.exactActive() {
+		return sh.auto.exact.Items(window)
+	}
+	return sh.heap(window).Items
+}
+
+// plotLength returns the number of plot points of the given window. The caller must hold mu.
+func (sh *shard) plotLength(window int) int {
+	if sh.decayed != nil {
+		return decayedHistoryLength
+	}
+	return plotLength(sh.sketches[window])
+}
+
+// countHistory fills the series (oldest first) with the item's counts over the given window, see countHistory.
+// The caller must hold mu.
+func (sh *shard) countHistory(window int, item heap.Item, series []float64) {
+	if sh.decayed != nil {
+		sh.decayed.countHistory(item.Item, series)
+		return
+	}
+	countHistory(sh.sketches[window].WindowSize, series, func(from, to float64) float64 {
+		return sh.countBetween(window, item, from, to)
+	})
+}
+
+// countBetween returns the item's count in the given window's tick ages [from, to), see countBetween.
+// The caller must hold mu.
+func (sh *shard) countBetween(window int, item heap.Item, from, to float64) float64 {
+	if sh.exactActive() {
+		return sh.auto.exact.CountBetween(item.Item, from, to)
+	}
+	return countBetween(sh.sketches[window], item, from, to)
+}
+
+// shardWidth returns the sketch width of each shard, so that all shards together have (at least) the configured width.
+func shardWidth() int {
+	return (config.Width + config.Shards - 1) / config.Shards
+}
+
+// shardIndex returns the index of the item's shard. The hash is independent of the sketches' bucket hashes,
+// so that the items of a shard are spread over all of its buckets.
+func shardIndex(item string, n int) int {
+	if n == 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	io.WriteString(h, item)
+	return int(h.Sum32() % uint32(n))
+}
+
+// textChunkSize is the size of the input chunks handed to the text parsing workers.
+const textChunkSize = 256 << 10
+
+// readTextItems reads the input in chunks of whole lines, which are split into items and queued by config.Shards workers.
+// The workers queue their chunks concurrently with each other and with the wall-clock ticks, so a line is counted
+// exactly once, but not necessarily in the tick in which it was read.
+func (m *model) readTextItems() {
+	chunks := make(chan []byte, config.Shards)
+	var wg sync.WaitGroup
+	for range config.Shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range chunks {
+				m.countLines(chunk)
+			}
+		}()
+	}
+	buf := make([]byte, textChunkSize)
+	var rest []byte
+	for {
+		n, err := m.input.Read(buf)
+		chunk := append(rest, buf[:n]...)
+		rest = nil
+		if err != nil {
+			if len(chunk) > 0 {
+				chunks <- chunk
+			}
+			break
+		}
+		i := bytes.LastIndexByte(chunk, '\n')
+		if i < 0 {
+			rest = chunk
+			continue
+		}
+		rest = slices.Clone(chunk[i+1:])
+		chunks <- chunk[:i+1]
+	}
+	close(chunks)
+	wg.Wait()
+}
+
+// countLines counts each of the chunk's lines once. The chunk is added to the stream totals as a whole.
+func (m *model) countLines(chunk []byte) {
+	var lines uint64
+	for len(chunk) > 0 {
+		line := chunk
+		if i := bytes.IndexByte(chunk, '\n'); i >= 0 {
+			line, chunk = chunk[:i], chunk[i+1:]
+		} else {
+			chunk = nil
+		}
+		m.queueRecord(record{Item: string(bytes.TrimSuffix(line, []byte{'\r'})), Count: 1})
+		lines++
+	}
+	m.totals.Add(lines, lines)
+}
+
+// topK returns the top-K items of the given window over all shards, and the total memory footprint of the sketches.
+func (m *model) topK(window int) ([]heap.Item, int) {
+	var (
+		items     []heap.Item
+		sizeBytes int
+	)
+	for _, sh := range m.shards {
+		sh.mu.Lock()
+		items = append(items, sh.topK(window)...)
+		if sh.decayed != nil {
+			sizeBytes += sh.decayed.SizeBytes()
+		}
+		for _, s := range sh.sketches {
+			sizeBytes += s.SizeBytes()
+		}
+		sh.mu.Unlock()
+	}
+	return topItems(items, config.K), sizeBytes
+}
+
+// topItems sorts the items by descending count (and then by name), and returns the first k.
+func topItems(items []heap.Item, k int) []heap.Item {
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].Count == items[j].Count {
+			return items[i].Item < items[j].Item
+		}
+		return items[i].Count > items[j].Count
+	})
+	return items[:min(len(items), k)]
+}
+
+// count returns the item's estimated (or exact) count in the given window.
+func (m *model) count(window int, item string) uint32 {
+	sh := m.shards[shardIndex(item, len(m.shards))]
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return sh.count(window, item)
+}
+
+// count returns the item's estimated (or exact) count in the given window. The caller must hold mu.
+func (sh *shard) count(window int, item string) uint32 {
+	if sh.decayed != nil {
+		return sh.decayed.Count(item)
+	}
+	if sh.exactActive() {
+		return sh.auto.exact.Count(window, item)
+	}
+	return sh.sketches[window].Count(item)
+}
+
+// updateThroughput updates the ingestion rate from the number of records counted since the last update.
+// The caller must hold mu.
+func (m *model) updateThroughput() {
+	now := time.Now()
+	ingested := m.ingested.Load()
+	if !m.throughputAt.IsZero() {
+		if elapsed := now.Sub(m.throughputAt).Seconds(); elapsed > 0 {
+			m.throughput = float64(ingested-m.throughputIngested) / elapsed
+		}
+	}
+	m.throughputAt, m.throughputIngested = now, ingested
+}
+
+func (m *model) throughputStatus() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status := fmt.Sprintf("ingested %s (%s/s)", formatSI(float64(m.ingested.Load())), formatSI(m.throughput))
+	if len(m.shards) > 1 {
+		status += fmt.Sprintf(" on %d shards", len(m.shards))
+	}
+	return status
+}
+
+// formatSI formats a number with an SI prefix, e.g. 1.2M.
+func formatSI(v float64) string {
+	if v < 999.5 {
+		return fmt.Sprintf("%.0f", v)
+	}
+	for _, prefix := range []string{"k", "M", "G", "T"} {
+		v /= 1000
+		if v < 999.95 {
+			return fmt.Sprintf("%.1f%s", v, prefix)
+		}
+	}
+	return fmt.Sprintf("%.1fP", v/1000)
+}
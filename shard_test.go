@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/keilerkonzept/topk/heap"
+)
+
+func BenchmarkReadTextItems(b *testing.B) {
+	var input bytes.Buffer
+	const lines = 1 << 16
+	for i := range lines {
+		fmt.Fprintf(&input, "item-%d\n", i*i%4096)
+	}
+	for _, shards := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			m := testModel(b, func(c *Config) { c.Shards = shards })
+			var wg sync.WaitGroup
+			for _, sh := range m.shards {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					m.applyQueued(sh)
+				}()
+			}
+			b.SetBytes(int64(input.Len()))
+			b.ResetTimer()
+			for range b.N {
+				m.input = bytes.NewReader(input.Bytes())
+				m.readTextItems()
+			}
+			for _, sh := range m.shards {
+				close(sh.queue)
+			}
+			wg.Wait()
+			b.ReportMetric(float64(b.N*lines)/b.Elapsed().Seconds(), "lines/s")
+			if total, _ := m.totals.Window(windowTicks()[0]); total.Events != uint64(b.N*lines) {
+				b.Errorf("counted %d lines, want %d", total.Events, b.N*lines)
+			}
+		})
+	}
+}
+
+func TestReadTextItemsSharded(t *testing.T) {
+	var input bytes.Buffer
+	for n := range 40_000 {
+		fmt.Fprintf(&input, "cold-%d\n", n%5000)
+		if hot := n%20 + 1; n/20 < 100*hot { // hot-i appears 100·i times
+			fmt.Fprintf(&input, "hot-%d\r\n", hot)
+		}
+	}
+	topK := func(shards int) []heap.Item {
+		m := testModel(t, func(c *Config) {
+			c.K = 10
+			c.Shards = shards
+			c.Width = 1 << 16
+		})
+		m.input = bytes.NewReader(input.Bytes())
+		m.readTextItems()
+		flushQueues(m)
+		items, _ := m.topK(0)
+		return items
+	}
+	single := topK(1)
+	if len(single) != 10 || single[0].Item != "hot-20" || single[0].Count != 2000 || single[9].Item != "hot-11" {
+		t.Fatalf("got single-shard top-k %v, want hot-20 (2000) to hot-11", single)
+	}
+	for _, shards := range []int{2, 4, 8} {
+		if got := topK(shards); !reflect.DeepEqual(got, single) {
+			t.Errorf("%d shards: got top-k %v, want %v", shards, got, single)
+		}
+	}
+}
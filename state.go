@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	tui "github.com/charmbracelet/bubbletea"
+	"github.com/keilerkonzept/topk/sliding"
+)
+
+const stateVersion = 2
+
+// state is the persisted sketch state, see -state-file.
+type state struct {
+	Version    int
+	Source     string    // Host name of the writer.
+	LatestTick time.Time // Time of the last tick applied to the sketches.
+	TickSize   time.Duration
+	Windows    []time.Duration
+	Shards     [][]*sliding.Sketch // Per shard, one sketch per window.
+	Totals     []streamTick        // Stream totals of the completed ticks, latest first.
+}
+
+// loadState reads the state file, and checks that it is compatible with the given sketches.
+// It returns nil (and no error) if the file does not exist.
+func loadState(path string, shards []*shard) (*state, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var st state
+	if err := gob.NewDecoder(bufio.NewReader(f)).Decode(&st); err != nil {
+		return nil, fmt.Errorf("read state file %s: %w", path, err)
+	}
+	if err := st.check(shards); err != nil {
+		return nil, fmt.Errorf("state file %s: %w", path, err)
+	}
+	return &st, nil
+}
+
+// check returns an error if the state's version, shards or sketch parameters do not match the given shards.
+func (st *state) check(shards []*shard) error {
+	if st.Version != stateVersion {
+		return fmt.Errorf("unsupported version %d", st.Version)
+	}
+	if len(st.Shards) != len(shards) {
+		return fmt.Errorf("%d shards do not match the configured %d shards", len(st.Shards), len(shards))
+	}
+	for i, sketches := range st.Shards {
+		if err := st.checkSketches(sketches, shards[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (st *state) checkSketches(sketches []*sliding.Sketch, sh *shard) error {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if st.TickSize != config.TickSize || !slices.Equal(st.Windows, config.Windows) || len(sketches) != len(sh.sketches) {
+		return fmt.Errorf("windows %v with tick size %v do not match the configured windows %v with tick size %v",
+			st.Windows, st.TickSize, config.Windows, config.TickSize)
+	}
+	for i, s := range sketches {
+		want := sh.sketches[i]
+		if s.K != want.K || s.Width != want.Width || s.Depth != want.Depth ||
+			s.WindowSize != want.WindowSize || s.BucketHistoryLength != want.BucketHistoryLength {
+			return fmt.Errorf("sketch parameters (k=%d, width=%d, depth=%d, window=%d ticks, history=%d) do not match the configured ones (k=%d, width=%d, depth=%d, window=%d ticks, history=%d)",
+				s.K, s.Width, s.Depth, s.WindowSize, s.BucketHistoryLength,
+				want.K, want.Width, want.Depth, want.WindowSize, want.BucketHistoryLength)
+		}
+		if s.Heap.Index == nil { // gob omits empty maps
+			s.Heap.Index = make(map[string]int, s.K)
+		}
+	}
+	return nil
+}
+
+// restoreState replaces the model's sketches with the restored ones.
+// The ticks that elapsed since the state was saved are applied right away for input ticked by the wall clock,
+// and otherwise on the first tick (see doSketchTicks), or when the input turns out to have no timestamps.
+func (m *model) restoreState(st *state) {
+	m.installSketches(st.Shards)
+	if st.Totals != nil {
+		m.totals.Restore(st.Totals)
+	}
+	m.mu.Lock()
+	m.resumeTick = st.LatestTick
+	m.latestTick = st.LatestTick
+	m.mu.Unlock()
+	switch config.Format {
+	case formatText, formatPrometheus:
+		m.catchUp()
+	}
+}
+
+// takeResumeTick returns the latest tick of the restored state once, or the zero time.
+func (m *model) takeResumeTick() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.resumeTick
+	m.resumeTick = time.Time{}
+	return t
+}
+
+// catchUp applies the ticks that elapsed between the restored state's latest tick and now, if they were not applied
+// yet, so that they don't age out input counted from now on. Wall-clock ticks then continue from now.
+func (m *model) catchUp() {
+	resume := m.takeResumeTick()
+	if resume.IsZero() {
+		return
+	}
+	now := m.doSketchTicks(time.Now(), resume)
+	m.mu.Lock()
+	m.resumeTick = now
+	if now.After(m.latestTick) {
+		m.latestTick = now
+	}
+	m.mu.Unlock()
+}
+
+// installSketches replaces the sketches of each shard. With -exact-below, the exact counts start over.
+func (m *model) installSketches(shards [][]*sliding.Sketch) {
+	for i, sh := range m.shards {
+		sh.mu.Lock()
+		sh.sketches = shards[i]
+		if sh.auto != nil {
+			sh.auto.Restart()
+		}
+		sh.mu.Unlock()
+	}
+}
+
+// encodeState encodes the sketch state.
+func (m *model) encodeState(enc *gob.Encoder) error {
+	m.mu.Lock()
+	latestTick := m.latestTick
+	m.mu.Unlock()
+	source, _ := os.Hostname()
+	shards := make([][]*sliding.Sketch, len(m.shards))
+	for i, sh := range m.shards {
+		sh.mu.Lock()
+		defer sh.mu.Unlock()
+		shards[i] = sh.sketches
+	}
+	return enc.Encode(state{
+		Version:    stateVersion,
+		Source:     source,
+		LatestTick: latestTick,
+		TickSize:   config.TickSize,
+		Windows:    config.Windows,
+		Shards:     shards,
+		Totals:     m.totals.Snapshot(),
+	})
+}
+
+// saveState atomically writes the sketch state to the given file.
+func (m *model) saveState(path string) error {
+	var buf bytes.Buffer
+	if err := m.encodeState(gob.NewEncoder(&buf)); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// statePusher sends sketch states to a merging instance (see -merge-listen) over one connection, so that the
+// merging instance can tell the states of several instances on the same host apart by their remote address.
+type statePusher struct {
+	mu   sync.Mutex
+	addr string
+	conn net.Conn
+	buf  bytes.Buffer
+	enc  *gob.Encoder // encodes into buf, continuing the connection's gob stream
+}
+
+// pushState sends the sketch state to the merging instance, connecting (again) if there is no connection.
+func (m *model) pushState(p *statePusher) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		conn, err := net.DialTimeout("tcp", p.addr, 10*time.Second)
+		if err != nil {
+			return err
+		}
+		p.conn = conn
+		p.enc = gob.NewEncoder(&p.buf)
+	}
+	p.buf.Reset()
+	err := m.encodeState(p.enc)
+	if err == nil {
+		p.conn.SetWriteDeadline(time.Now().Add(time.Minute))
+		_, err = p.buf.WriteTo(p.conn)
+	}
+	if err != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+	return err
+}
+
+type CheckpointTickMsg time.Time
+
+func doCheckpointTick() tui.Cmd {
+	if (config.StateFile == "" && config.StatePush == "") || config.StateInterval <= 0 {
+		return nil
+	}
+	return tui.Every(config.StateInterval, func(t time.Time) tui.Msg {
+		return CheckpointTickMsg(t)
+	})
+}
+
+func (m *model) checkpointCmd() tui.Cmd {
+	return func() tui.Msg {
+		var saveErr, pushErr error
+		if config.StateFile != "" {
+			saveErr = m.saveState(config.StateFile)
+		}
+		if m.pusher != nil {
+			if pushErr = m.pushState(m.pusher); pushErr != nil {
+				pushErr = fmt.Errorf("push to %s: %w", config.StatePush, pushErr)
+			}
+		}
+		m.mu.Lock()
+		m.checkpointAt = time.Now()
+		m.checkpointErr = errors.Join(saveErr, pushErr)
+		m.mu.Unlock()
+		return nil
+	}
+}
+
+func (m *model) checkpointStatus() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case m.checkpointAt.IsZero():
+		return "no checkpoint yet"
+	case m.checkpointErr != nil:
+		return "checkpoint failed: " + strings.ReplaceAll(m.checkpointErr.Error(), "\n", "; ")
+	}
+	return "checkpoint at " + m.checkpointAt.Format(time.TimeOnly)
+}
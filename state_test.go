@@ -0,0 +1,98 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRestoreStateCatchesUp(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state")
+	saved := testModel(t, nil)
+	saved.add(record{Item: "a", Count: 5})
+	saved.add(record{Item: "b", Count: 3})
+	flushQueues(saved)
+	tests := []struct {
+		name     string
+		downtime time.Duration
+		a, b     uint32
+	}{
+		{"within the window", 4 * time.Second, 5, 4},
+		{"longer than the window", time.Hour, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved.latestTick = time.Now().Add(-tt.downtime).Truncate(config.TickSize)
+			if err := saved.saveState(path); err != nil {
+				t.Fatal(err)
+			}
+			m := testModel(t, nil)
+			st, err := loadState(path, m.shards)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.restoreState(st)
+			m.add(record{Item: "b"}) // counted after the restore, before the first wall-clock tick
+			flushQueues(m)
+			if a, b := m.count(0, "a"), m.count(0, "b"); a != tt.a || b != tt.b {
+				t.Errorf("got counts a=%d b=%d, want a=%d b=%d", a, b, tt.a, tt.b)
+			}
+			if since := time.Since(m.latestTick); since < 0 || since > 2*config.TickSize {
+				t.Errorf("got latest tick %v, want the current tick", m.latestTick)
+			}
+		})
+	}
+}
+
+func TestLoadStateMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state")
+	if err := testModel(t, nil).saveState(path); err != nil {
+		t.Fatal(err)
+	}
+	m := testModel(t, func(c *Config) { c.Width *= 2 })
+	if _, err := loadState(path, m.shards); err == nil || !strings.Contains(err.Error(), "width=") {
+		t.Errorf("got error %v, want a sketch parameter mismatch", err)
+	}
+	if st, err := loadState(filepath.Join(t.TempDir(), "missing"), m.shards); st != nil || err != nil {
+		t.Errorf("got %v, %v for a missing file, want nil, nil", st, err)
+	}
+}
+func TestLoadStateWindowMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state")
+	saved := testModel(t, func(c *Config) {
+		c.Windows = []time.Duration{4 * c.TickSize, 8 * c.TickSize}
+	})
+	if err := saved.saveState(path); err != nil {
+		t.Fatal(err)
+	}
+	for _, windows := range [][]int{{4}, {4, 16}, {8, 4}} {
+		m := testModel(t, func(c *Config) {
+			c.Windows = nil
+			for _, w := range windows {
+				c.Windows = append(c.Windows, time.Duration(w)*c.TickSize)
+			}
+		})
+		if _, err := loadState(path, m.shards); err == nil || !strings.Contains(err.Error(), "windows") {
+			t.Errorf("windows %v ticks: got error %v, want a window mismatch", windows, err)
+		}
+	}
+}
+
+func TestCheckpointStatus(t *testing.T) {
+	m := testModel(t, func(c *Config) {
+		c.StateFile = filepath.Join(t.TempDir(), "missing", "state")
+	})
+	if status := m.checkpointStatus(); status != "no checkpoint yet" {
+		t.Errorf("got status %q before the first checkpoint", status)
+	}
+	m.checkpointCmd()()
+	if status := m.checkpointStatus(); !strings.HasPrefix(status, "checkpoint failed: ") {
+		t.Errorf("got status %q, want a checkpoint error", status)
+	}
+	config.StateFile = filepath.Join(t.TempDir(), "state")
+	m.checkpointCmd()()
+	if status := m.checkpointStatus(); !strings.HasPrefix(status, "checkpoint at ") {
+		t.Errorf("got status %q, want the checkpoint time", status)
+	}
+}
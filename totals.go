@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// streamTick is the number of records, and their total count (weight), in one tick.
+type streamTick struct {
+	Events, Weight uint64
+}
+
+// streamRate is the number of records, and their total count (weight), per second.
+type streamRate struct {
+	Events, Weight float64
+}
+
+// streamTotals counts all records of the stream per tick, over the longest window.
+// Records are counted as they are read, before sharding, hierarchy expansion, and -queue-overflow drops.
+// Readers add them in batches where they can (the text workers once per chunk), and the current tick's
+// sums are folded into the ring when the totals tick.
+type streamTotals struct {
+	events, weight atomic.Uint64 // current tick
+
+	mu      sync.Mutex
+	ring    []streamTick // completed ticks, ring[(head+age-1)%len(ring)] holds the one from `age` ticks ago
+	head    int
+	ticked  int     // number of completed ticks, up to len(ring)
+	factor  float64 // with -mode=decayed, the decay factor per tick
+	decayed float64 // with -mode=decayed, the decayed weight of the completed ticks
+}
+
+func newStreamTotals(windows []int) *streamTotals {
+	t := &streamTotals{ring: make([]streamTick, max(1, maxOf(windows)-1))}
+	if config.Mode == modeDecayed {
+		t.factor = decayFactor()
+	}
+	return t
+}
+
+// Add counts a batch of records with the given total count (weight) in the current tick.
+func (t *streamTotals) Add(events, weight uint64) {
+	t.events.Add(events)
+	t.weight.Add(weight)
+}
+
+func (t *streamTotals) Ticks(n int) {
+	current := streamTick{Events: t.events.Swap(0), Weight: t.weight.Swap(0)}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for range min(n, len(t.ring)) {
+		t.head = (t.head + len(t.ring) - 1) % len(t.ring)
+		t.ring[t.head] = streamTick{}
+	}
+	if n <= len(t.ring) {
+		t.ring[(t.head+n-1)%len(t.ring)] = current
+	}
+	t.ticked = min(t.ticked+n, len(t.ring))
+	t.decayed = (t.decayed + float64(current.Weight)) * math.Pow(t.factor, float64(n))
+}
+
+// Decayed returns the total weight decayed like the counts of -mode=decayed, i.e. the total of the decayed counts.
+func (t *streamTotals) Decayed() float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.decayed + float64(t.weight.Load())
+}
+
+// Window returns the totals of a window of the given number of ticks, and the average rate
+// over the window's completed ticks.
+func (t *streamTotals) Window(size int) (total streamTick, rate streamRate) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	total = streamTick{Events: t.events.Load(), Weight: t.weight.Load()}
+	var completed streamTick
+	n := min(size-1, t.ticked)
+	for age := 1; age <= n; age++ {
+		tick := t.ring[(t.head+age-1)%len(t.ring)]
+		completed.Events += tick.Events
+		completed.Weight += tick.Weight
+	}
+	total.Events += completed.Events
+	total.Weight += completed.Weight
+	if n > 0 {
+		seconds := float64(n) * config.TickSize.Seconds()
+		rate = streamRate{Events: float64(completed.Events) / seconds, Weight: float64(completed.Weight) / seconds}
+	}
+	return total, rate
+}
+
+// Snapshot returns the completed ticks, latest first.
+func (t *streamTotals) Snapshot() []streamTick {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ticks := make([]streamTick, len(t.ring))
+	for age := range ticks {
+		ticks[age] = t.ring[(t.head+age)%len(t.ring)]
+	}
+	return ticks
+}
+
+// Restore replaces the completed ticks (latest first), and drops the current tick's counts.
+func (t *streamTotals) Restore(ticks []streamTick) {
+	t.events.Store(0)
+	t.weight.Store(0)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	clear(t.ring)
+	t.head = 0
+	copy(t.ring, ticks)
+	t.ticked = len(t.ring)
+}
+
+// mergeTotals sums the snapshots' completed ticks (latest first), each shifted by its lag (in ticks)
+// behind the latest snapshot.
+func mergeTotals(snapshots [][]streamTick, lags []int) []streamTick {
+	var merged []streamTick
+	for i, ticks := range snapshots {
+		for age, tick := range ticks {
+			age += lags[i]
+			for len(merged) <= age {
+				merged = append(merged, streamTick{})
+			}
+			merged[age].Events += tick.Events
+			merged[age].Weight += tick.Weight
+		}
+	}
+	return merged
+}
+
+// updateTotals updates the active window's stream totals. The caller must hold mu.
+func (m *model) updateTotals() {
+	m.windowTotal, m.windowRate = m.totals.Window(windowTicks()[m.window])
+}
+
+// share returns the item count's share of the active window's total weight, in percent.
+// The caller must hold mu.
+func (m *model) share(count uint32) (float64, bool) {
+	if m.windowTotal.Weight == 0 {
+		return 0, false
+	}
+	return 100 * float64(count) / float64(m.windowTotal.Weight), true
+}
+
+func (m *model) totalsStatus() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	total, rate := m.windowTotal, m.windowRate
+	status := fmt.Sprintf("window %s events (%s/s)", formatSI(float64(total.Events)), formatSI(rate.Events))
+	if total.Weight != total.Events {
+		status += fmt.Sprintf(", weight %s (%s/s)", formatSI(float64(total.Weight)), formatSI(rate.Weight))
+	}
+	return status
+}
+
+// formatShare formats an item's share of the window's traffic, e.g. 12.3%.
+func formatShare(share float64) string {
+	if share < 0.095 {
+		return fmt.Sprintf("%.2f%%", share)
+	}
+	return fmt.Sprintf("%.1f%%", share)
+}
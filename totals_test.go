@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStreamTotals(t *testing.T) {
+	totals := newStreamTotals([]int{2, 4}) // 3 completed ticks, and the current one
+	totals.Add(2, 5)
+	totals.Ticks(1)
+	totals.Add(1, 1)
+	totals.Ticks(2) // the counts were added in the first of the two ticks
+	totals.Add(3, 3)
+
+	if got, want := totals.Snapshot(), []streamTick{{0, 0}, {1, 1}, {2, 5}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got completed ticks %v, want %v", got, want)
+	}
+	seconds := config.TickSize.Seconds()
+	tests := []struct {
+		size  int
+		total streamTick
+		rate  streamRate
+	}{
+		{1, streamTick{3, 3}, streamRate{}},
+		{2, streamTick{3, 3}, streamRate{}}, // the completed tick is empty
+		{3, streamTick{4, 4}, streamRate{1 / (2 * seconds), 1 / (2 * seconds)}},
+		{4, streamTick{6, 9}, streamRate{3 / (3 * seconds), 6 / (3 * seconds)}},
+	}
+	for _, tt := range tests {
+		total, rate := totals.Window(tt.size)
+		if total != tt.total || rate != tt.rate {
+			t.Errorf("Window(%d) = %v, %v, want %v, %v", tt.size, total, rate, tt.total, tt.rate)
+		}
+	}
+
+	totals.Ticks(5) // longer than the ring: the current tick's counts expire, too
+	if got, want := totals.Snapshot(), []streamTick{{0, 0}, {0, 0}, {0, 0}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got completed ticks %v after a full window, want %v", got, want)
+	}
+	if total, _ := totals.Window(4); total != (streamTick{}) {
+		t.Errorf("got window total %v after a full window, want none", total)
+	}
+
+	totals.Add(7, 7)
+	totals.Restore([]streamTick{{1, 2}, {3, 4}})
+	if got, want := totals.Snapshot(), []streamTick{{1, 2}, {3, 4}, {0, 0}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got completed ticks %v after restoring, want %v", got, want)
+	}
+	if total, _ := totals.Window(4); total != (streamTick{4, 6}) {
+		t.Errorf("got window total %v after restoring, want %v", total, streamTick{4, 6})
+	}
+}
+
+func TestMergeTotals(t *testing.T) {
+	latest := []streamTick{{1, 1}, {2, 2}}
+	other := []streamTick{{10, 20}, {30, 40}}
+	tests := []struct {
+		lags []int
+		want []streamTick
+	}{
+		{[]int{0, 0}, []streamTick{{11, 21}, {32, 42}}},
+		{[]int{0, 1}, []streamTick{{1, 1}, {12, 22}, {30, 40}}},
+		{[]int{0, 3}, []streamTick{{1, 1}, {2, 2}, {0, 0}, {10, 20}, {30, 40}}},
+	}
+	for _, tt := range tests {
+		if got := mergeTotals([][]streamTick{latest, other}, tt.lags); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("lags %v: got %v, want %v", tt.lags, got, tt.want)
+		}
+	}
+}